@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+	"github.com/mmcdole/runes/pkg/proxy"
+	"github.com/mmcdole/runes/pkg/transport"
+)
+
+// runMSSP implements "runes mssp <host> <port>": it connects, waits for the
+// server's MSSP data and prints it as JSON.
+func runMSSP(args []string) int {
+	fs := flag.NewFlagSet("mssp", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 10*time.Second, "How long to wait for MSSP data")
+	proxyURL := fs.String("proxy", "", "Proxy to connect through, e.g. socks5://host:1080")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runes mssp [-timeout 10s] [-proxy url] <host> <port>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	host := fs.Arg(0)
+	port, err := strconv.Atoi(fs.Arg(1))
+	if err != nil || port < 1 || port > 65535 {
+		fmt.Fprintf(os.Stderr, "Invalid port: %s\n", fs.Arg(1))
+		return 2
+	}
+
+	dialer, err := proxy.FromURL(*proxyURL, proxy.Direct)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	u := &url.URL{Scheme: "telnet", Host: net.JoinHostPort(host, strconv.Itoa(port))}
+	raw, err := transport.Open(ctx, u, transport.Options{Dialer: dialer})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect: %v\n", err)
+		return 1
+	}
+	conn := telnet.FromConn(raw, false)
+	defer conn.Close()
+
+	received := make(chan map[string][]string, 1)
+	conn.SetEventHandler(func(e telnet.TelnetEvent) {
+		if msg, ok := e.(telnet.MSSPEvent); ok {
+			select {
+			case received <- msg.Variables:
+			default:
+			}
+		}
+	})
+
+	// Reading drives negotiation, the text itself isn't needed
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, conn)
+		closed <- err
+	}()
+
+	var vars map[string][]string
+	select {
+	case vars = <-received:
+	case err := <-closed:
+		// The server may send MSSP and hang up straight away
+		select {
+		case vars = <-received:
+		default:
+			if err == nil {
+				err = io.EOF
+			}
+			fmt.Fprintf(os.Stderr, "Connection closed before MSSP data arrived: %v\n", err)
+			return 1
+		}
+	case <-time.After(*timeout):
+		fmt.Fprintf(os.Stderr, "No MSSP data received within %v\n", *timeout)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode MSSP data: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(out))
+	return 0
+}
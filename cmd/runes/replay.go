@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mmcdole/runes/pkg/client"
+	"github.com/mmcdole/runes/pkg/events"
+)
+
+// runReplay implements "runes replay <file>": it plays a recording made with
+// /record through telnet processing, the Lua scripts and the display as if
+// it came from the server, then exits.
+func runReplay(args []string, scriptDir string, debug bool) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "Playback speed, e.g. 2 for twice as fast, 0 for no pauses")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: runes [-scripts dir] replay [-speed 1] <file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	c, err := client.NewClient(events.New(), scriptDir, debug)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create client: %v\n", err)
+		return 1
+	}
+	defer c.Close()
+
+	if err := c.Replay(file, *speed); err != nil {
+		fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
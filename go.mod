@@ -2,4 +2,11 @@ module github.com/mmcdole/runes
 
 go 1.23.0
 
-require github.com/yuin/gopher-lua v1.1.1 // indirect
+require (
+	github.com/coder/websocket v1.8.14
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/crypto v0.36.0
+	golang.org/x/sys v0.31.0
+	golang.org/x/term v0.30.0
+	golang.org/x/text v0.23.0
+)
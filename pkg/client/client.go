@@ -2,24 +2,77 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mmcdole/runes/pkg/events"
 	"github.com/mmcdole/runes/pkg/luaengine"
+	"github.com/mmcdole/runes/pkg/protocol/msp"
+	"github.com/mmcdole/runes/pkg/protocol/mxp"
 	"github.com/mmcdole/runes/pkg/protocol/telnet"
+	"github.com/mmcdole/runes/pkg/transport"
 )
 
 // Client handles the core MUD client functionality
 type Client struct {
-	conn          Connection
 	engine        *luaengine.LuaEngine
 	events        *events.EventProcessor
 	display       *Display
 	lineProcessor *LineProcessor
-	connected     bool
 	debug         bool
+	mxp           *mxp.Parser // Set while the server has MXP enabled
+	msp           bool        // Set while the server has MSP enabled
+	serverEcho    atomic.Bool // Set while the server echoes input, e.g. for passwords
+
+	// The current connection, also used from the read and dial goroutines
+	connMu    sync.Mutex
+	conn      Connection
+	connected bool
+
+	// Work for the goroutine that owns the Lua engine, see run
+	queueMu   sync.Mutex
+	queue     []func()
+	queueWake chan struct{}
+
+	// Partial lines are flushed as prompts after promptTimeout
+	promptTimeout time.Duration
+	promptTimer   *time.Timer // Stopped while no partial line is held
+
+	// Connections are opened in the background so a slow server can't block
+	// input, and can be abandoned with Disconnect
+	dialMu         sync.Mutex
+	dialing        *connectAttempt // Attempt in progress, nil if none
+	connectTimeout time.Duration
+	idleTimeout    time.Duration
+	proxy          string // Default proxy URL, empty to connect directly
+
+	// Keepalive probes and idle events, run by monitor for each connection
+	keepaliveMu   sync.Mutex
+	keepalive     time.Duration // TCP keepalive period, 0 for none
+	probeInterval time.Duration
+	probeKind     string
+	idleEvent     time.Duration
+	monitorWake   chan struct{} // Signalled when the settings change
+	lastRead      atomic.Int64  // When data last arrived, in Unix nanoseconds
+	lastActivity  atomic.Int64  // When text was last sent or received
+
+	// Session recording, nil when not recording
+	recordMu  sync.Mutex
+	recording *recording
+
+	// Protocol settings applied to each new connection
+	gmcpSupports   []string
+	compressOutput bool              // Offer MCCP3 to the server
+	environ        map[string]string // Custom NEW-ENVIRON variables
+	encoding       string            // Empty to use the connection's default
+	sizeOverride   [2]int            // Window size set from scripts, zero to follow the display
 }
 
 // NewClient creates a new MUD client
@@ -29,19 +82,35 @@ func NewClient(eventProcessor *events.EventProcessor, userScriptDir string, debu
 	// to capture all events emitted during core script loading
 	
 	client := &Client{
-		events:        eventProcessor,
-		display:       NewDisplay(os.Stdout),
-		lineProcessor: NewLineProcessor(),
-		debug:         debug,
+		events:         eventProcessor,
+		display:        NewDisplay(os.Stdout),
+		lineProcessor:  NewLineProcessor(),
+		debug:          debug,
+		promptTimeout:  defaultPromptTimeout,
+		connectTimeout: defaultConnectTimeout,
+		keepalive:      defaultKeepalive,
+		compressOutput: true,
+		probeKind:      ProbeNOP,
+		monitorWake:    make(chan struct{}, 1),
+		queueWake:      make(chan struct{}, 1),
 	}
+	client.promptTimer = time.NewTimer(defaultPromptTimeout)
+	client.promptTimer.Stop()
 
 	client.setupEventHandlers()
+	client.display.SetSize(terminalSize())
+	client.display.OnResize(func(width, height int) {
+		client.updateWindowSize()
+	})
 
 	engine := luaengine.New(userScriptDir, eventProcessor)
 	if err := engine.Initialize(); err != nil {
 		return nil, fmt.Errorf("failed to initialize lua engine: %v", err)
 	}
 	client.engine = engine
+	client.updateWindowSize()
+	go client.run()
+	go client.watchResize()
 
 	// Start input handling
 	go client.inputLoop()
@@ -55,27 +124,61 @@ func (c *Client) setupEventHandlers() {
 	c.events.Subscribe(events.EventCommand, c.handleCommand)
 	c.events.Subscribe(events.EventOutput, c.handleOutput)
 	c.events.Subscribe(events.EventQuit, c.handleQuit)
+	c.events.Subscribe(events.EventGMCPSend, c.handleGMCPSend)
+	c.events.Subscribe(events.EventGMCPSupports, c.handleGMCPSupports)
+	c.events.Subscribe(events.EventMSDPSend, c.handleMSDPSend)
+	c.events.Subscribe(events.EventCompressionStats, c.handleCompressionStats)
+	c.events.Subscribe(events.EventSetCompressOutput, c.handleSetCompressOutput)
+	c.events.Subscribe(events.EventSetWindowSize, c.handleSetWindowSize)
+	c.events.Subscribe(events.EventSetPromptTimeout, c.handleSetPromptTimeout)
+	c.events.Subscribe(events.EventSetEncoding, c.handleSetEncoding)
+	c.events.Subscribe(events.EventTelnetSend, c.handleTelnetSend)
+	c.events.Subscribe(events.EventTelnetOption, c.handleTelnetOption)
+	c.events.Subscribe(events.EventSetEnviron, c.handleSetEnviron)
+	c.events.Subscribe(events.EventSetProxy, c.handleSetProxy)
+	c.events.Subscribe(events.EventSetConnectTimeout, c.handleSetConnectTimeout)
+	c.events.Subscribe(events.EventSetIdleTimeout, c.handleSetIdleTimeout)
+	c.events.Subscribe(events.EventSetKeepalive, c.handleSetKeepalive)
+	c.events.Subscribe(events.EventSetProbe, c.handleSetProbe)
+	c.events.Subscribe(events.EventSetIdleEvent, c.handleSetIdleEvent)
+	c.events.Subscribe(events.EventRecordStart, c.handleRecordStart)
+	c.events.Subscribe(events.EventRecordStop, c.handleRecordStop)
 }
 
 func (c *Client) handleConnect(e events.Event) {
-	data, ok := e.Data.(struct {
-		Host string
-		Port int
-	})
+	data, ok := e.Data.(events.ConnectRequest)
 	if !ok {
 		return
 	}
 
-	if err := c.Connect(data.Host, data.Port); err == nil {
-		// Only emit Connected event on success
-		c.events.Emit(events.Event{
-			Type: events.EventConnected,
-			Data: data,
-		})
+	rawURL := data.URL
+	if rawURL == "" {
+		rawURL = serverURL(data.Host, data.Port, data.TLS)
 	}
+
+	opts := transport.Options{
+		SSH: &transport.SSHConfig{
+			KeyFile:  data.Identity,
+			Insecure: data.InsecureHostKey,
+		},
+		Plain: data.Plain,
+	}
+	if data.TLS {
+		opts.TLS = &telnet.TLSConfig{
+			CAFile:       data.CAFile,
+			Fingerprints: data.Fingerprints,
+			Insecure:     data.Insecure,
+		}
+	}
+
+	c.startConnect(rawURL, opts, data.Proxy)
 }
 
 func (c *Client) handleDisconnect(e events.Event) {
+	if c.cancelConnect() {
+		// The attempt reports its own failure
+		return
+	}
 	if err := c.Disconnect(); err == nil {
 		c.events.Emit(events.Event{
 			Type: events.EventDisconnected,
@@ -101,109 +204,347 @@ func (c *Client) handleOutput(e events.Event) {
 }
 
 func (c *Client) handleQuit(e events.Event) {
-	c.Close()
+	c.close()
 	os.Exit(0)
 }
 
 // IsConnected returns true if the client is connected
 func (c *Client) IsConnected() bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
 	return c.connected
 }
 
-// Connect connects to a MUD server
-func (c *Client) Connect(host string, port int) error {
-	if c.conn != nil {
-		c.conn.Close()
+// current returns the connection, or nil if the client isn't connected
+func (c *Client) current() Connection {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if !c.connected {
+		return nil
 	}
+	return c.conn
+}
 
-	telnetConn, err := telnet.NewTelnetConnection(host, port, c.debug)
+// Connect connects to a MUD server, using TLS if tlsConfig isn't nil. The
+// connection goes through proxyURL if given, otherwise the default proxy.
+// It gives up when ctx is done or the connect timeout passes, reporting
+// progress and failures with EventConnectProgress.
+func (c *Client) Connect(ctx context.Context, host string, port int, tlsConfig *telnet.TLSConfig, proxyURL string) error {
+	return c.ConnectURL(ctx, serverURL(host, port, tlsConfig != nil), transport.Options{TLS: tlsConfig}, proxyURL)
+}
+
+// ConnectURL connects like Connect to the server at a URL such as
+// telnet://example.com:4000, wss://example.com/mud or
+// ssh://player@example.com. The client fills in the dialer and terminal
+// settings of opts. It must not be called from an event handler, as the
+// new connection is handed to the goroutine running the handlers.
+func (c *Client) ConnectURL(ctx context.Context, rawURL string, opts transport.Options, proxyURL string) error {
+	var err error
+	c.do(func() { opts, err = c.dialOptions(opts, proxyURL) })
+	if err != nil {
+		c.connectProgress("failed", rawURL, err.Error())
+		return err
+	}
+	conn, err := c.open(ctx, rawURL, opts)
 	if err != nil {
-		c.events.Emit(events.Event{
-			Type: events.EventRawOutput,
-			Data: fmt.Sprintf("Failed to connect: %v", err),
-		})
 		return err
 	}
+	c.do(func() { c.start(conn) })
+	return nil
+}
 
-	c.conn = telnetConn
-	c.connected = true
+// dialOptions fills in the dialer for proxyURL, or the default proxy, and
+// the terminal settings of opts. It runs on the client's goroutine, which
+// owns the window size, so open doesn't have to read client state.
+func (c *Client) dialOptions(opts transport.Options, proxyURL string) (transport.Options, error) {
+	dialer, err := c.dialer(proxyURL)
+	if err != nil {
+		return opts, err
+	}
+	opts.Dialer = dialer
+	opts.Term, _ = terminalType()
+	opts.Cols, opts.Rows = c.windowSize()
+	return opts, nil
+}
 
-	// Start reading from connection
-	go c.readLoop()
+// open opens a connection to the server at a URL with options from
+// dialOptions, reporting progress and failures like Connect. It runs off the
+// client's goroutine.
+func (c *Client) open(ctx context.Context, rawURL string, opts transport.Options) (Connection, error) {
+	u, err := transport.Parse(rawURL)
+	if err != nil {
+		c.connectProgress("failed", rawURL, err.Error())
+		return nil, err
+	}
 
-	return nil
+	c.connMu.Lock()
+	old := c.conn
+	c.connMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+
+	timeout, _ := c.dialTimeouts()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, err := transport.Open(ctx, u, opts)
+	if err == nil && ctx.Err() != nil {
+		// Cancelled just as the connection opened
+		conn.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.connectFailed(ctx, dialAddress(u), timeout, err)
+		return nil, err
+	}
+
+	if transport.Telnet(conn) {
+		return telnet.FromConn(conn, c.debug), nil
+	}
+	return &plainConnection{Conn: conn}, nil
+}
+
+// start makes conn the current connection and starts reading from it
+func (c *Client) start(conn Connection) {
+	c.attach(conn)
+
+	done := make(chan struct{})
+	go func() {
+		c.readLoop(conn)
+		close(done)
+	}()
+	go c.monitor(conn, done)
 }
 
-// Disconnect closes the connection to the MUD server
+// attach applies the client's settings to a new connection and makes it
+// the current one
+func (c *Client) attach(conn Connection) {
+	if telnetConn, ok := conn.(*telnet.TelnetConnection); ok {
+		telnetConn.SetEventHandler(c.handleTelnetEvent)
+		telnetConn.SetGMCPSupports(c.gmcpSupports)
+		telnetConn.SetCompressOutput(c.compressOutput)
+		telnetConn.SetTerminalType(terminalType())
+		for name, value := range c.environ {
+			telnetConn.SetEnvironVar(name, value)
+		}
+		if c.encoding != "" {
+			telnetConn.SetEncoding(c.encoding)
+		}
+	}
+	if naws, ok := conn.(nawsConnection); ok {
+		naws.SetWindowSize(c.windowSize())
+	}
+	c.recordConnection(conn)
+	now := time.Now().UnixNano()
+	c.lastRead.Store(now)
+	c.lastActivity.Store(now)
+
+	c.connMu.Lock()
+	c.conn = conn
+	c.connected = true
+	c.connMu.Unlock()
+	c.mxp = nil
+	c.msp = false
+}
+
+// Disconnect closes the connection to the MUD server, or abandons the
+// connection attempt in progress
 func (c *Client) Disconnect() error {
-	if !c.connected {
+	c.cancelConnect()
+	c.connMu.Lock()
+	conn, connected := c.conn, c.connected
+	c.connected = false
+	c.connMu.Unlock()
+	if !connected {
 		return nil
 	}
-	c.connected = false
-	return c.conn.Close()
+	return conn.Close()
 }
 
 // SendCommand sends a command to the MUD server
 func (c *Client) SendCommand(cmd string) error {
-	if !c.connected {
+	conn := c.current()
+	if conn == nil {
 		return fmt.Errorf("not connected")
 	}
-	_, err := c.conn.Write([]byte(cmd + "\n"))
+	c.lastActivity.Store(time.Now().UnixNano())
+	_, err := conn.Write(encode(conn, cmd+"\n"))
 	return err
 }
 
 // Send sends data to the server
 func (c *Client) Send(data string) {
-	if !c.connected {
+	conn := c.current()
+	if conn == nil {
 		return
 	}
-	c.conn.Write([]byte(data + "\n"))
+	c.lastActivity.Store(time.Now().UnixNano())
+	conn.Write(encode(conn, data+"\n"))
 }
 
-func (c *Client) readLoop() {
+// readLoop reads from conn until it closes, passing what arrives to the
+// client's goroutine
+func (c *Client) readLoop(conn Connection) {
+	_, idleTimeout := c.dialTimeouts()
+	deadlineConn, canTimeout := conn.(deadlineConnection)
 	buf := make([]byte, 4096)
 	for {
-		n, err := c.conn.Read(buf)
+		if idleTimeout > 0 && canTimeout {
+			deadlineConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		n, err := conn.Read(buf)
 		if err != nil {
-			if err != io.EOF {
-				fmt.Printf("Read error: %v\n", err)
-			}
-			c.connected = false
-			c.events.Emit(events.Event{
-				Type: events.EventDisconnected,
-			})
+			c.do(func() { c.readFailed(conn, err, idleTimeout) })
 			return
 		}
 
+		now := time.Now().UnixNano()
+		c.lastRead.Store(now)
 		if n > 0 {
-			// Process the raw data into lines
-			lines := c.lineProcessor.Write(buf[:n])
-			for _, line := range lines {
-				c.events.Emit(events.Event{
-					Type: events.EventRawOutput,
-					Data: line,
-				})
-			}
+			c.lastActivity.Store(now)
+
+			// Process the raw data into lines. The buffer is reused once
+			// this returns.
+			c.do(func() {
+				lines := c.lineProcessor.Write(buf[:n])
+				for _, line := range lines {
+					c.processLine(line, false)
+				}
+				c.schedulePromptFlush()
+			})
 		}
 	}
 }
 
+// readFailed reports why reading from conn stopped and, unless a newer
+// connection has replaced it, marks the client disconnected
+func (c *Client) readFailed(conn Connection, err error, idleTimeout time.Duration) {
+	var compressionErr *telnet.CompressionError
+	if errors.As(err, &compressionErr) {
+		// A broken compressed stream can't be resynchronised
+		conn.Close()
+		c.events.Emit(events.Event{
+			Type: events.EventRawOutput,
+			Data: fmt.Sprintf("Connection closed: %v", err),
+		})
+	} else if errors.Is(err, os.ErrDeadlineExceeded) {
+		conn.Close()
+		c.events.Emit(events.Event{
+			Type: events.EventRawOutput,
+			Data: fmt.Sprintf("Connection closed: nothing received for %v", idleTimeout),
+		})
+	} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+		fmt.Printf("Read error: %v\n", err)
+	}
+
+	c.connMu.Lock()
+	replaced := c.conn != conn
+	c.connMu.Unlock()
+	if replaced {
+		// Replaced by a newer connection, which owns the client state
+		return
+	}
+	c.flushPartialLine()
+	c.setServerEcho(false)
+	c.connMu.Lock()
+	c.connected = false
+	c.connMu.Unlock()
+	c.events.Emit(events.Event{
+		Type: events.EventDisconnected,
+	})
+}
+
+// processLine applies MSP and MXP to a line of output or a prompt and
+// passes it on for scripts and display
+func (c *Client) processLine(line string, prompt bool) {
+	eventType := events.EventRawOutput
+	if prompt {
+		eventType = events.EventPrompt
+	}
+
+	if c.msp {
+		var triggers []msp.Trigger
+		line, triggers = msp.Parse(line)
+		for _, trigger := range triggers {
+			c.emitMSP(trigger)
+		}
+		if line == "" && len(triggers) > 0 {
+			// The line only held sound triggers
+			return
+		}
+	}
+
+	if c.mxp == nil {
+		c.events.Emit(events.Event{
+			Type: eventType,
+			Data: line,
+		})
+		return
+	}
+
+	parsed := c.mxp.Parse(line)
+	if conn := c.current(); conn != nil {
+		for _, reply := range parsed.Replies {
+			conn.Write([]byte(reply))
+		}
+	}
+	if parsed.Text == "" && line != "" {
+		// The line only held MXP definitions or mode changes
+		return
+	}
+
+	c.events.Emit(events.Event{
+		Type: eventType,
+		Data: parsed.Text,
+	})
+	for _, link := range parsed.Links {
+		c.events.Emit(events.Event{
+			Type: events.EventMXPLink,
+			Data: struct {
+				Start    int
+				End      int
+				Text     string
+				Commands []string
+				Hints    []string
+				URL      string
+				Prompt   bool
+			}{link.Start, link.End, link.Text, link.Commands, link.Hints, link.URL, link.Prompt},
+		})
+	}
+}
+
 func (c *Client) inputLoop() {
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		input := scanner.Text()
+		c.do(func() {
+			if c.serverEcho.Load() {
+				c.sendMasked(input)
+				return
+			}
 
-		// Emit the raw input event for Lua to handle
-		c.events.Emit(events.Event{
-			Type: events.EventRawInput,
-			Data: input,
+			// Emit the raw input event for Lua to handle
+			c.events.Emit(events.Event{
+				Type: events.EventRawInput,
+				Data: input,
+			})
 		})
 	}
 }
 
 // Close closes the client connection
 func (c *Client) Close() {
-	if c.connected {
-		c.Disconnect()
-	}
+	c.do(c.close)
+}
+
+// close closes the connection on the client's goroutine
+func (c *Client) close() {
+	c.cancelConnect()
+	c.StopRecording()
+	c.Disconnect()
+	c.setServerEcho(false)
 }
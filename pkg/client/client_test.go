@@ -0,0 +1,356 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/runes/pkg/events"
+	"github.com/mmcdole/runes/pkg/mudtest"
+)
+
+// session is a client connected to a mudtest server, with the events it
+// emits collected for checking
+type session struct {
+	client *Client
+	server *mudtest.Conn
+	events chan events.Event
+}
+
+// startSession creates a client with the core scripts and connects it to a
+// new mudtest server
+func startSession(t *testing.T) *session {
+	t.Helper()
+	processor := events.New()
+	s := &session{events: make(chan events.Event, 256)}
+	for _, eventType := range []events.EventType{
+		events.EventRawOutput, events.EventPrompt, events.EventGMCP, events.EventEcho,
+		events.EventWindowSize, events.EventDisconnected, events.EventIdle, events.EventMXPLink,
+		events.EventMSP,
+	} {
+		processor.Subscribe(eventType, func(e events.Event) {
+			s.events <- e
+		})
+	}
+
+	client, err := NewClient(processor, "", false)
+	if err != nil {
+		t.Fatal("NewClient failed:", err)
+	}
+	t.Cleanup(client.Close)
+	s.client = client
+
+	server := mudtest.NewServer(t)
+	if err := client.Connect(context.Background(), server.Host(), server.Port(), nil, ""); err != nil {
+		t.Fatal("Connect failed:", err)
+	}
+	s.server = server.Accept()
+	return s
+}
+
+// expectEvent waits for an event of the given type whose data prints as
+// want, skipping any others
+func (s *session) expectEvent(t *testing.T, eventType events.EventType, want string) {
+	t.Helper()
+	var skipped []string
+	deadline := time.After(mudtest.DefaultTimeout)
+	for {
+		select {
+		case e := <-s.events:
+			got := fmt.Sprint(e.Data)
+			if e.Type == eventType && got == want {
+				return
+			}
+			skipped = append(skipped, fmt.Sprintf("%s %q", e.Type, got))
+		case <-deadline:
+			t.Fatalf("timed out waiting for %s %q, got %v", eventType, want, skipped)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := startSession(t)
+
+	s.server.SendLine("Welcome to the test MUD!")
+	s.expectEvent(t, events.EventRawOutput, "Welcome to the test MUD!")
+
+	s.server.Prompt("HP: 10> ")
+	s.expectEvent(t, events.EventPrompt, "HP: 10> ")
+
+	s.client.SendCommand("look")
+	s.server.ExpectLine("look")
+
+	s.server.Close()
+	s.expectEvent(t, events.EventDisconnected, "<nil>")
+}
+
+func TestSessionConnectCommand(t *testing.T) {
+	processor := events.New()
+	s := &session{events: make(chan events.Event, 64)}
+	for _, eventType := range []events.EventType{events.EventRawOutput, events.EventConnected} {
+		processor.Subscribe(eventType, func(e events.Event) {
+			s.events <- e
+		})
+	}
+	client, err := NewClient(processor, "", false)
+	if err != nil {
+		t.Fatal("NewClient failed:", err)
+	}
+	t.Cleanup(client.Close)
+
+	// Input reaches scripts on the client's goroutine, and the connection
+	// is opened in the background
+	server := mudtest.NewServer(t)
+	client.do(func() {
+		processor.Emit(events.Event{
+			Type: events.EventRawInput,
+			Data: fmt.Sprintf("/connect %s %d", server.Host(), server.Port()),
+		})
+	})
+	s.server = server.Accept()
+	s.expectEvent(t, events.EventConnected, fmt.Sprintf("{%s %d  }", server.Host(), server.Port()))
+	if !client.IsConnected() {
+		t.Error("expected the client to be connected")
+	}
+
+	s.server.SendLine("Welcome")
+	s.expectEvent(t, events.EventRawOutput, "Welcome")
+}
+
+func TestSessionReplay(t *testing.T) {
+	s := startSession(t)
+	path := filepath.Join(t.TempDir(), "session.rec")
+	if err := s.client.StartRecording(path); err != nil {
+		t.Fatal("StartRecording failed:", err)
+	}
+
+	want := []string{
+		"raw_output Welcome",
+		"prompt HP: 10> ",
+		"raw_output Exits: north",
+		"raw_output Goodbye",
+	}
+	s.server.SendLine("Welcome")
+	s.expectEvent(t, events.EventRawOutput, "Welcome")
+	s.server.Will(mudtest.OptMCCP2)
+	s.server.ExpectCommand(mudtest.DO, mudtest.OptMCCP2)
+	s.server.StartCompression()
+	s.server.Prompt("HP: 10> ")
+	s.expectEvent(t, events.EventPrompt, "HP: 10> ")
+	s.server.SendLine("Exits: north")
+	s.expectEvent(t, events.EventRawOutput, "Exits: north")
+	s.server.EndCompression()
+	s.server.SendLine("Goodbye")
+	s.expectEvent(t, events.EventRawOutput, "Goodbye")
+	if _, err := s.client.StopRecording(); err != nil {
+		t.Fatal("StopRecording failed:", err)
+	}
+
+	// Play the recording back through a second client
+	processor := events.New()
+	var got []string
+	for _, eventType := range []events.EventType{events.EventRawOutput, events.EventPrompt} {
+		processor.Subscribe(eventType, func(e events.Event) {
+			got = append(got, fmt.Sprintf("%s %v", e.Type, e.Data))
+		})
+	}
+	client, err := NewClient(processor, "", false)
+	if err != nil {
+		t.Fatal("NewClient failed:", err)
+	}
+	t.Cleanup(client.Close)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal("Failed to open recording:", err)
+	}
+	defer file.Close()
+	if err := client.Replay(file, 0); err != nil {
+		t.Fatal("Replay failed:", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected the replay to give %q, got %q", want, got)
+	}
+}
+
+func TestSessionPromptTimeout(t *testing.T) {
+	s := startSession(t)
+
+	// Without GA or EOR, a partial line becomes a prompt once the timeout
+	// passes
+	s.client.do(func() {
+		s.client.handleSetPromptTimeout(events.Event{Data: 20 * time.Millisecond})
+	})
+	s.server.Send("By what name are you known? ")
+	s.expectEvent(t, events.EventPrompt, "By what name are you known? ")
+
+	// The rest of a line stops the timeout
+	s.server.Send("Exits: ")
+	s.server.SendLine("north")
+	s.expectEvent(t, events.EventRawOutput, "Exits: north")
+}
+
+func TestSessionGMCP(t *testing.T) {
+	s := startSession(t)
+
+	s.server.Will(mudtest.OptGMCP)
+	s.server.ExpectCommand(mudtest.DO, mudtest.OptGMCP)
+	if hello := s.server.ExpectGMCP("Core.Hello"); hello == "" {
+		t.Error("expected Core.Hello to name the client")
+	}
+
+	s.server.SendGMCP("Char.Vitals", `{"hp":10}`)
+	s.expectEvent(t, events.EventGMCP, `{Char.Vitals [123 34 104 112 34 58 49 48 125]}`)
+}
+
+func TestSessionCompression(t *testing.T) {
+	s := startSession(t)
+
+	s.server.Will(mudtest.OptMCCP2)
+	s.server.ExpectCommand(mudtest.DO, mudtest.OptMCCP2)
+	s.server.StartCompression()
+	s.server.SendLine("This line was compressed")
+	s.expectEvent(t, events.EventRawOutput, "This line was compressed")
+
+	s.server.EndCompression()
+	s.server.SendLine("This one wasn't")
+	s.expectEvent(t, events.EventRawOutput, "This one wasn't")
+
+	stats, ok := s.client.CompressionStats()
+	if !ok {
+		t.Fatal("expected compression stats for a telnet connection")
+	}
+	if stats.Active || stats.Streams != 1 || stats.CompressedBytes == 0 ||
+		stats.DecompressedBytes < int64(len("This line was compressed\r\n")) {
+		t.Errorf("unexpected stats after one compressed line: %+v", stats)
+	}
+	var requested events.CompressionStats
+	s.client.do(func() {
+		s.client.handleCompressionStats(events.Event{Type: events.EventCompressionStats, Data: &requested})
+	})
+	if !requested.Connected || requested.Streams != stats.Streams || requested.DecompressedBytes != stats.DecompressedBytes {
+		t.Errorf("expected scripts to be given %+v, got %+v", stats, requested)
+	}
+
+	// MCCP3 compresses what the client sends
+	s.server.Will(mudtest.OptMCCP3)
+	s.server.ExpectCommand(mudtest.DO, mudtest.OptMCCP3)
+	s.server.ExpectSubnegotiation(mudtest.OptMCCP3)
+	s.client.SendCommand("north")
+	s.server.ExpectLine("north")
+}
+
+func TestSessionCompressOutput(t *testing.T) {
+	s := startSession(t)
+	setCompressOutput := func(enabled bool) {
+		s.client.do(func() {
+			s.client.handleSetCompressOutput(events.Event{Type: events.EventSetCompressOutput, Data: enabled})
+		})
+	}
+
+	setCompressOutput(false)
+	s.server.Will(mudtest.OptMCCP3)
+	s.server.ExpectCommand(mudtest.DONT, mudtest.OptMCCP3)
+
+	setCompressOutput(true)
+	s.server.Will(mudtest.OptMCCP3)
+	s.server.ExpectCommand(mudtest.DO, mudtest.OptMCCP3)
+	s.server.ExpectSubnegotiation(mudtest.OptMCCP3)
+	s.client.SendCommand("look")
+	s.server.ExpectLine("look")
+	if stats, _ := s.client.CompressionStats(); !stats.OutboundActive {
+		t.Error("expected commands to be compressed")
+	}
+}
+
+func TestSessionMXPLinks(t *testing.T) {
+	s := startSession(t)
+
+	s.server.Will(mudtest.OptMXP)
+	s.server.ExpectCommand(mudtest.DO, mudtest.OptMXP)
+	s.server.SendLine("\x1b[1zExits: <send>north</send> or <send href=\"go south\">south</send>")
+	// The offsets locate the link text within the formatted line
+	s.expectEvent(t, events.EventRawOutput, "Exits: \x1b[4mnorth\x1b[0m or \x1b[4msouth\x1b[0m")
+	s.expectEvent(t, events.EventMXPLink, "{11 16 north [north] []  false}")
+	s.expectEvent(t, events.EventMXPLink, "{28 33 south [go south] []  false}")
+}
+
+func TestSessionMSP(t *testing.T) {
+	s := startSession(t)
+
+	// Text that looks like a trigger is left alone until MSP is enabled
+	s.server.SendLine("Type !!SOUND(thunder.wav) to hear it")
+	s.expectEvent(t, events.EventRawOutput, "Type !!SOUND(thunder.wav) to hear it")
+
+	s.server.Will(mudtest.OptMSP)
+	s.server.ExpectCommand(mudtest.DO, mudtest.OptMSP)
+	s.server.SendLine("Thunder rolls. !!SOUND(thunder.wav V=50)")
+	s.expectEvent(t, events.EventMSP, "{false thunder.wav false 50 1 50 false  }")
+	s.expectEvent(t, events.EventRawOutput, "Thunder rolls. ")
+}
+
+func TestSessionEcho(t *testing.T) {
+	s := startSession(t)
+
+	s.server.Send("Password: ")
+	s.server.Will(mudtest.OptEcho)
+	s.server.ExpectCommand(mudtest.DO, mudtest.OptEcho)
+	s.expectEvent(t, events.EventEcho, "true")
+	if !s.client.serverEcho.Load() {
+		t.Error("expected the client to know the server is echoing")
+	}
+
+	s.server.Wont(mudtest.OptEcho)
+	s.expectEvent(t, events.EventEcho, "false")
+}
+
+func TestSessionNAWS(t *testing.T) {
+	s := startSession(t)
+
+	s.server.Do(mudtest.OptNAWS)
+	s.server.ExpectCommand(mudtest.WILL, mudtest.OptNAWS)
+	size := s.server.ExpectSubnegotiation(mudtest.OptNAWS)
+	if len(size) != 4 {
+		t.Fatalf("expected a 4 byte window size, got %v", size)
+	}
+	cols, rows := s.client.windowSize()
+	if binary.BigEndian.Uint16(size) != uint16(cols) || binary.BigEndian.Uint16(size[2:]) != uint16(rows) {
+		t.Errorf("expected %dx%d, got %v", cols, rows, size)
+	}
+}
+
+func TestSessionKeepalive(t *testing.T) {
+	s := startSession(t)
+
+	if err := s.client.SetProbe(20*time.Millisecond, "ping"); err == nil {
+		t.Error("expected an unknown probe to be refused")
+	}
+	if err := s.client.SetProbe(20*time.Millisecond, ProbeAYT); err != nil {
+		t.Fatal("SetProbe failed:", err)
+	}
+	s.server.ExpectCommand(mudtest.AYT, 0)
+	s.server.ExpectCommand(mudtest.AYT, 0)
+	s.client.SetProbe(0, ProbeNOP)
+
+	// Probes don't count as activity
+	s.client.SetIdleEvent(50 * time.Millisecond)
+	deadline := time.After(mudtest.DefaultTimeout)
+	for {
+		select {
+		case e := <-s.events:
+			if e.Type != events.EventIdle {
+				continue
+			}
+			if idle := e.Data.(time.Duration); idle < 50*time.Millisecond {
+				t.Errorf("expected at least 50ms idle, got %v", idle)
+			}
+			return
+		case <-deadline:
+			t.Fatal("timed out waiting for an idle event")
+		}
+	}
+}
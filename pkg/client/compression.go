@@ -0,0 +1,55 @@
+package client
+
+import (
+	"github.com/mmcdole/runes/pkg/events"
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+)
+
+// compressionConnection is implemented by connections that can be
+// compressed with MCCP
+type compressionConnection interface {
+	CompressionStats() telnet.CompressionStats
+	SetCompressOutput(enabled bool)
+}
+
+// CompressionStats returns the MCCP statistics of the current connection.
+// It returns false if the client isn't connected, or the connection doesn't
+// speak telnet.
+func (c *Client) CompressionStats() (telnet.CompressionStats, bool) {
+	if conn, ok := c.current().(compressionConnection); ok {
+		return conn.CompressionStats(), true
+	}
+	return telnet.CompressionStats{}, false
+}
+
+// handleSetCompressOutput offers or refuses MCCP3 on this and future
+// connections
+func (c *Client) handleSetCompressOutput(e events.Event) {
+	enabled, ok := e.Data.(bool)
+	if !ok {
+		return
+	}
+	c.compressOutput = enabled
+	if conn, ok := c.current().(compressionConnection); ok {
+		conn.SetCompressOutput(enabled)
+	}
+}
+
+// handleCompressionStats fills in the statistics requested by a script
+func (c *Client) handleCompressionStats(e events.Event) {
+	data, ok := e.Data.(*events.CompressionStats)
+	if !ok {
+		return
+	}
+	stats, ok := c.CompressionStats()
+	*data = events.CompressionStats{
+		Connected:               ok,
+		Active:                  stats.Active,
+		Streams:                 stats.Streams,
+		CompressedBytes:         stats.CompressedBytes,
+		DecompressedBytes:       stats.DecompressedBytes,
+		OutboundActive:          stats.OutboundActive,
+		OutboundBytes:           stats.OutboundBytes,
+		OutboundCompressedBytes: stats.OutboundCompressedBytes,
+	}
+}
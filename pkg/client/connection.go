@@ -2,9 +2,15 @@ package client
 
 import (
 	"io"
+	"time"
 )
 
 // Connection represents a connection to a MUD server
 type Connection interface {
 	io.ReadWriteCloser
 }
+
+// deadlineConnection is implemented by connections whose reads can time out
+type deadlineConnection interface {
+	SetReadDeadline(deadline time.Time) error
+}
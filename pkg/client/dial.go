@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/mmcdole/runes/pkg/events"
+	"github.com/mmcdole/runes/pkg/transport"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+
+	// How long to wait on the preferred address family before also trying
+	// the other one, as recommended by RFC 8305
+	happyEyeballsDelay = 250 * time.Millisecond
+)
+
+// connectAttempt is a connection being opened in the background
+type connectAttempt struct {
+	cancel context.CancelFunc
+}
+
+// SetConnectTimeout sets how long opening a connection, including any proxy
+// and TLS handshakes, may take. Zero waits as long as the system allows.
+func (c *Client) SetConnectTimeout(timeout time.Duration) {
+	c.dialMu.Lock()
+	c.connectTimeout = timeout
+	c.dialMu.Unlock()
+}
+
+// SetIdleTimeout sets how long the connection may go without data from the
+// server before it is closed. Zero never closes it.
+func (c *Client) SetIdleTimeout(timeout time.Duration) {
+	c.dialMu.Lock()
+	c.idleTimeout = timeout
+	c.dialMu.Unlock()
+}
+
+// startConnect opens a connection in the background, replacing any attempt
+// already in progress, and emits EventConnected if it succeeds
+func (c *Client) startConnect(rawURL string, opts transport.Options, proxyURL string) {
+	opts, err := c.dialOptions(opts, proxyURL)
+	if err != nil {
+		c.cancelConnect()
+		c.connectProgress("failed", rawURL, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempt := &connectAttempt{cancel: cancel}
+
+	c.dialMu.Lock()
+	if c.dialing != nil {
+		c.dialing.cancel()
+	}
+	c.dialing = attempt
+	c.dialMu.Unlock()
+
+	go func() {
+		conn, err := c.open(ctx, rawURL, opts)
+		// The result goes back to the client's goroutine, which owns the
+		// connection state and scripts
+		c.post(func() {
+			defer cancel()
+			c.dialMu.Lock()
+			current := c.dialing == attempt
+			if current {
+				c.dialing = nil
+			}
+			c.dialMu.Unlock()
+
+			if err != nil {
+				return
+			}
+			u, _ := transport.Parse(rawURL)
+			if !current {
+				// Abandoned after the connection opened
+				conn.Close()
+				c.connectProgress("failed", dialAddress(u), "cancelled")
+				return
+			}
+			c.start(conn)
+			c.events.Emit(events.Event{
+				Type: events.EventConnected,
+				Data: c.connectionStatus(transport.HostPort(u)),
+			})
+		})
+	}()
+}
+
+// serverURL returns the telnet:// URL of a server, or its tls:// URL if
+// useTLS is set
+func serverURL(host string, port int, useTLS bool) string {
+	scheme := "telnet"
+	if useTLS {
+		scheme = "tls"
+	}
+	u := url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port))}
+	return u.String()
+}
+
+// dialAddress returns the address a URL connects to for progress reports: its
+// host, or the command of an exec: URL
+func dialAddress(u *url.URL) string {
+	if u.Host == "" {
+		return u.Opaque
+	}
+	return u.Host
+}
+
+// cancelConnect aborts the connection attempt in progress, returning false
+// if there isn't one
+func (c *Client) cancelConnect() bool {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+
+	if c.dialing == nil {
+		return false
+	}
+	c.dialing.cancel()
+	c.dialing = nil
+	return true
+}
+
+// dialTimeouts returns the connect and idle timeouts
+func (c *Client) dialTimeouts() (connect, idle time.Duration) {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	return c.connectTimeout, c.idleTimeout
+}
+
+// connectProgress reports a stage of a connection attempt: "resolving" a
+// host name, "connecting" to an address, or "failed" with a reason. It is
+// called while dialing, so the event is posted to the client's goroutine.
+func (c *Client) connectProgress(stage, address, reason string) {
+	c.post(func() {
+		c.events.Emit(events.Event{
+			Type: events.EventConnectProgress,
+			Data: struct {
+				Stage   string
+				Address string
+				Reason  string
+			}{stage, address, reason},
+		})
+	})
+}
+
+// connectFailed reports why a connection attempt failed, preferring the
+// timeout or cancellation that ended it over the error it caused
+func (c *Client) connectFailed(ctx context.Context, address string, timeout time.Duration, err error) {
+	reason := err.Error()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		reason = fmt.Sprintf("timed out after %v", timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		reason = "cancelled"
+	}
+	c.connectProgress("failed", address, reason)
+}
+
+// directDialer dials without a proxy, reporting each stage. Host names that
+// resolve to both IPv4 and IPv6 addresses are dialed dual-stack: the second
+// family is tried if the first hasn't connected within happyEyeballsDelay.
+type directDialer struct {
+	client *Client
+}
+
+func (d directDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if host, _, err := net.SplitHostPort(address); err == nil && net.ParseIP(host) == nil {
+		d.client.connectProgress("resolving", host, "")
+	}
+
+	dialer := &net.Dialer{
+		FallbackDelay: happyEyeballsDelay,
+		ControlContext: func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+			d.client.connectProgress("connecting", address, "")
+			return nil
+		},
+	}
+	if keepalive, _, _, _ := d.client.keepaliveSettings(); keepalive > 0 {
+		dialer.KeepAliveConfig = net.KeepAliveConfig{Enable: true, Idle: keepalive, Interval: keepalive}
+	} else {
+		dialer.KeepAlive = -1
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (c *Client) handleSetConnectTimeout(e events.Event) {
+	if timeout, ok := e.Data.(time.Duration); ok && timeout >= 0 {
+		c.SetConnectTimeout(timeout)
+	}
+}
+
+func (c *Client) handleSetIdleTimeout(e events.Event) {
+	if timeout, ok := e.Data.(time.Duration); ok && timeout >= 0 {
+		c.SetIdleTimeout(timeout)
+	}
+}
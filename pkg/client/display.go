@@ -16,6 +16,11 @@ type Display struct {
 	current   string
 	lineCount int
 	mu        sync.RWMutex
+
+	// Size of the terminal the display is shown in
+	width    int
+	height   int
+	onResize func(width, height int)
 }
 
 func (d *Display) Write(p []byte) (n int, err error) {
@@ -38,6 +43,8 @@ func NewDisplay(output io.Writer) *Display {
 		buffers:   make(map[string]*Buffer),
 		current:   MainBuffer,
 		lineCount: 50,
+		width:     80,
+		height:    24,
 	}
 	d.buffers[MainBuffer] = &Buffer{Name: MainBuffer, Visible: true}
 	return d
@@ -103,3 +110,34 @@ func (d *Display) ListBuffers() []string {
 	}
 	return buffers
 }
+
+// Size returns the width and height of the display in characters
+func (d *Display) Size() (int, int) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.width, d.height
+}
+
+// SetSize records a new display size and notifies the resize handler if
+// it changed
+func (d *Display) SetSize(width, height int) {
+	d.mu.Lock()
+	if width == d.width && height == d.height {
+		d.mu.Unlock()
+		return
+	}
+	d.width, d.height = width, height
+	onResize := d.onResize
+	d.mu.Unlock()
+
+	if onResize != nil {
+		onResize(width, height)
+	}
+}
+
+// OnResize registers a function called whenever the display size changes
+func (d *Display) OnResize(handler func(width, height int)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onResize = handler
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"github.com/mmcdole/runes/pkg/events"
+)
+
+// setServerEcho records whether the server is echoing input. While it is,
+// as it does while asking for a password, typed text is hidden and lines go
+// straight to the server so they don't reach aliases, history or logs.
+func (c *Client) setServerEcho(enabled bool) {
+	if c.serverEcho.Swap(enabled) == enabled {
+		return
+	}
+	setTerminalEcho(!enabled)
+	c.events.Emit(events.Event{
+		Type: events.EventEcho,
+		Data: enabled,
+	})
+}
+
+// sendMasked sends a line typed while the server was echoing
+func (c *Client) sendMasked(input string) {
+	// The terminal didn't echo the newline either
+	c.display.Write(nil)
+	c.SendCommand(input)
+}
@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package client
+
+// setTerminalEcho does nothing where the terminal can't be controlled;
+// typed text stays visible.
+func setTerminalEcho(on bool) {}
@@ -0,0 +1,26 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package client
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTerminalEcho switches the terminal echoing typed characters on or off.
+// Line editing stays on, so input is still read a line at a time.
+func setTerminalEcho(on bool) {
+	fd := int(os.Stdin.Fd())
+	termios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		// Not a terminal
+		return
+	}
+	if on {
+		termios.Lflag |= unix.ECHO
+	} else {
+		termios.Lflag &^= unix.ECHO
+	}
+	unix.IoctlSetTermios(fd, ioctlSetTermios, termios)
+}
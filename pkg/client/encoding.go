@@ -0,0 +1,53 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/mmcdole/runes/pkg/events"
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+)
+
+// encodingConnection is implemented by connections that transcode text
+type encodingConnection interface {
+	SetEncoding(name string) error
+	Encoding() string
+	Encode(text string) []byte
+}
+
+// encode converts text to the connection's encoding
+func encode(conn Connection, text string) []byte {
+	if conn, ok := conn.(encodingConnection); ok {
+		return conn.Encode(text)
+	}
+	return []byte(text)
+}
+
+// handleSetEncoding changes the encoding used for this and future
+// connections
+func (c *Client) handleSetEncoding(e events.Event) {
+	name, ok := e.Data.(string)
+	if !ok {
+		return
+	}
+	encoding, ok := telnet.LookupEncoding(name)
+	if !ok {
+		c.events.Emit(events.Event{
+			Type: events.EventRawOutput,
+			Data: fmt.Sprintf("Unsupported encoding: %s", name),
+		})
+		return
+	}
+
+	c.encoding = encoding
+	if conn, ok := c.current().(encodingConnection); ok {
+		conn.SetEncoding(encoding)
+	}
+	c.emitEncoding(encoding)
+}
+
+func (c *Client) emitEncoding(encoding string) {
+	c.events.Emit(events.Event{
+		Type: events.EventEncoding,
+		Data: encoding,
+	})
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mmcdole/runes/pkg/events"
+)
+
+// defaultKeepalive is the TCP keepalive period, as Go uses by default
+const defaultKeepalive = 15 * time.Second
+
+// Kinds of keepalive probe
+const (
+	ProbeNOP = "nop" // IAC NOP, which servers ignore
+	ProbeAYT = "ayt" // IAC AYT, which servers answer with some text
+)
+
+// probeConnection is implemented by connections that can send telnet
+// keepalive probes
+type probeConnection interface {
+	SendNOP() error
+	SendAYT() error
+}
+
+// SetKeepalive sets the TCP keepalive period for new connections: how long
+// a silent connection waits before the system checks the server is still
+// there, and how often it checks again. Zero turns keepalive off.
+func (c *Client) SetKeepalive(period time.Duration) {
+	c.keepaliveMu.Lock()
+	c.keepalive = period
+	c.keepaliveMu.Unlock()
+}
+
+// SetProbe sends a telnet probe of the given kind, ProbeNOP or ProbeAYT,
+// whenever nothing has arrived from the server for interval. A probe that
+// can't be sent ends the connection. With an idle timeout set, AYT probes
+// also catch a server that has stopped answering. Zero stops probing.
+func (c *Client) SetProbe(interval time.Duration, kind string) error {
+	if kind != ProbeNOP && kind != ProbeAYT {
+		return fmt.Errorf("unknown probe %q, expected %s or %s", kind, ProbeNOP, ProbeAYT)
+	}
+	c.keepaliveMu.Lock()
+	c.probeInterval = interval
+	c.probeKind = kind
+	c.keepaliveMu.Unlock()
+	c.wakeMonitor()
+	return nil
+}
+
+// SetIdleEvent emits EventIdle once nothing has been sent to or received
+// from the server for after, and again each time that long passes. Zero
+// stops the events.
+func (c *Client) SetIdleEvent(after time.Duration) {
+	c.keepaliveMu.Lock()
+	c.idleEvent = after
+	c.keepaliveMu.Unlock()
+	c.wakeMonitor()
+}
+
+// keepaliveSettings returns the TCP keepalive period, probe settings and
+// idle event period
+func (c *Client) keepaliveSettings() (keepalive, probeInterval time.Duration, probeKind string, idleEvent time.Duration) {
+	c.keepaliveMu.Lock()
+	defer c.keepaliveMu.Unlock()
+	return c.keepalive, c.probeInterval, c.probeKind, c.idleEvent
+}
+
+// wakeMonitor makes the monitor pick up changed settings
+func (c *Client) wakeMonitor() {
+	select {
+	case c.monitorWake <- struct{}{}:
+	default:
+	}
+}
+
+// monitor sends keepalive probes on conn and emits idle events until done
+// is closed. It runs on its own goroutine, so events go through post.
+func (c *Client) monitor(conn Connection, done <-chan struct{}) {
+	var lastProbe, lastIdle time.Time
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		_, probeInterval, probeKind, idleEvent := c.keepaliveSettings()
+		now := time.Now()
+		// Settings changes wake the monitor, so this is only a fallback
+		wait := time.Hour
+
+		if _, ok := conn.(probeConnection); ok && probeInterval > 0 {
+			due := latest(time.Unix(0, c.lastRead.Load()), lastProbe).Add(probeInterval)
+			if !now.Before(due) {
+				if !c.sendProbe(conn, probeKind) {
+					return
+				}
+				lastProbe = now
+				due = now.Add(probeInterval)
+			}
+			wait = min(wait, due.Sub(now))
+		}
+
+		if idleEvent > 0 {
+			lastActivity := time.Unix(0, c.lastActivity.Load())
+			due := latest(lastActivity, lastIdle).Add(idleEvent)
+			if !now.Before(due) {
+				c.emitIdle(now.Sub(lastActivity))
+				lastIdle = now
+				due = now.Add(idleEvent)
+			}
+			wait = min(wait, due.Sub(now))
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-done:
+			return
+		case <-c.monitorWake:
+		case <-timer.C:
+		}
+	}
+}
+
+// sendProbe sends a keepalive probe, closing the connection and returning
+// false if it can't be sent
+func (c *Client) sendProbe(conn Connection, kind string) bool {
+	probe := conn.(probeConnection)
+	send := probe.SendNOP
+	if kind == ProbeAYT {
+		send = probe.SendAYT
+	}
+	if err := send(); err != nil {
+		conn.Close()
+		c.post(func() {
+			c.events.Emit(events.Event{
+				Type: events.EventRawOutput,
+				Data: fmt.Sprintf("Connection lost: %v", err),
+			})
+		})
+		return false
+	}
+	return true
+}
+
+// emitIdle posts EventIdle to the client's goroutine, which owns the
+// scripts that handle it
+func (c *Client) emitIdle(idle time.Duration) {
+	c.post(func() {
+		c.events.Emit(events.Event{
+			Type: events.EventIdle,
+			Data: idle,
+		})
+	})
+}
+
+// latest returns the later of two times
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (c *Client) handleSetKeepalive(e events.Event) {
+	if period, ok := e.Data.(time.Duration); ok && period >= 0 {
+		c.SetKeepalive(period)
+	}
+}
+
+func (c *Client) handleSetProbe(e events.Event) {
+	data, ok := e.Data.(struct {
+		Interval time.Duration
+		Kind     string
+	})
+	if !ok || data.Interval < 0 {
+		return
+	}
+	if err := c.SetProbe(data.Interval, data.Kind); err != nil {
+		c.events.Emit(events.Event{
+			Type: events.EventRawOutput,
+			Data: fmt.Sprintf("Invalid probe: %v", err),
+		})
+	}
+}
+
+func (c *Client) handleSetIdleEvent(e events.Event) {
+	if after, ok := e.Data.(time.Duration); ok && after >= 0 {
+		c.SetIdleEvent(after)
+	}
+}
@@ -2,35 +2,45 @@ package client
 
 import (
 	"bytes"
+	"sync"
 )
 
-// LineProcessor processes incoming data into lines
-type LineProcessor struct{}
+// LineProcessor processes incoming data into lines. A trailing partial line
+// is held until the rest of it arrives or it is flushed as a prompt.
+type LineProcessor struct {
+	mu      sync.Mutex
+	partial []byte
+}
 
 // NewLineProcessor returns a new LineProcessor
 func NewLineProcessor() *LineProcessor {
 	return &LineProcessor{}
 }
 
-// Write processes incoming data and returns lines
+// Write processes incoming data and returns the lines it completes
 func (p *LineProcessor) Write(data []byte) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	var lines []string
 	buf := data
 
 	for {
 		idx := bytes.IndexByte(buf, '\n')
 		if idx == -1 {
-			// No more newlines, send remaining data as a line
-			if len(buf) > 0 {
-				lines = append(lines, string(buf))
-			}
+			// No more newlines, hold the remaining data until the line ends
+			p.partial = append(p.partial, buf...)
 			return lines
 		}
 
-		// Extract the line (including any \r)
+		// Extract the line (including any \r), joined to the held data
 		line := buf[:idx]
-		if idx > 0 && line[idx-1] == '\r' {
-			line = line[:idx-1]
+		if len(p.partial) > 0 {
+			line = append(p.partial, line...)
+			p.partial = nil
+		}
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
 		}
 		lines = append(lines, string(line))
 
@@ -38,3 +48,23 @@ func (p *LineProcessor) Write(data []byte) []string {
 		buf = buf[idx+1:]
 	}
 }
+
+// Pending reports whether a partial line is being held
+func (p *LineProcessor) Pending() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.partial) > 0
+}
+
+// Flush returns the partial line being held, if there is one
+func (p *LineProcessor) Flush() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.partial) == 0 {
+		return "", false
+	}
+	line := string(p.partial)
+	p.partial = nil
+	return line, true
+}
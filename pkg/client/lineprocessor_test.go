@@ -0,0 +1,32 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineProcessor(t *testing.T) {
+	p := NewLineProcessor()
+
+	if lines := p.Write([]byte("one\r\ntw")); !reflect.DeepEqual(lines, []string{"one"}) {
+		t.Errorf("expected [one], got %q", lines)
+	}
+	if !p.Pending() {
+		t.Error("expected a partial line to be held")
+	}
+
+	// The rest of a line split across reads completes it
+	if lines := p.Write([]byte("o\r")); lines != nil {
+		t.Errorf("expected no lines, got %q", lines)
+	}
+	if lines := p.Write([]byte("\nthree\n\nHP> ")); !reflect.DeepEqual(lines, []string{"two", "three", ""}) {
+		t.Errorf("expected [two three \"\"], got %q", lines)
+	}
+
+	if prompt, ok := p.Flush(); !ok || prompt != "HP> " {
+		t.Errorf("expected prompt %q, got %q (%v)", "HP> ", prompt, ok)
+	}
+	if _, ok := p.Flush(); ok {
+		t.Error("expected nothing held after a flush")
+	}
+}
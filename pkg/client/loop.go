@@ -0,0 +1,60 @@
+package client
+
+// The Lua engine isn't safe for concurrent use, so it and the client state
+// scripts drive (the line processor, MXP parser, protocol settings and so
+// on) belong to a single goroutine running run. Goroutines reading input,
+// reading from the server or dialing hand their work to it with post or do.
+
+// run carries out work posted to the client, in the order it was posted,
+// and flushes held partial lines as prompts when the prompt timeout passes
+func (c *Client) run() {
+	for {
+		select {
+		case <-c.queueWake:
+			for fn := c.next(); fn != nil; fn = c.next() {
+				fn()
+			}
+		case <-c.promptTimer.C:
+			c.flushPrompt()
+		}
+	}
+}
+
+// post queues fn to run on the client's goroutine. It doesn't wait, so it
+// can also be called from that goroutine.
+func (c *Client) post(fn func()) {
+	c.queueMu.Lock()
+	c.queue = append(c.queue, fn)
+	c.queueMu.Unlock()
+
+	select {
+	case c.queueWake <- struct{}{}:
+	default:
+	}
+}
+
+// do runs fn on the client's goroutine and waits for it to finish. It must
+// not be called from that goroutine.
+func (c *Client) do(fn func()) {
+	done := make(chan struct{})
+	c.post(func() {
+		defer close(done)
+		fn()
+	})
+	<-done
+}
+
+// next removes and returns the first queued function, or nil if the queue
+// is empty
+func (c *Client) next() func() {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if len(c.queue) == 0 {
+		return nil
+	}
+	fn := c.queue[0]
+	c.queue[0] = nil
+	c.queue = c.queue[1:]
+	return fn
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// plainConnection is a connection carrying plain text rather than telnet,
+// such as an SSH session. It records what it receives like a telnet
+// connection does.
+type plainConnection struct {
+	net.Conn
+
+	recordMu sync.Mutex
+	recorder io.Writer
+}
+
+func (p *plainConnection) Read(b []byte) (int, error) {
+	n, err := p.Conn.Read(b)
+	if n > 0 {
+		p.recordMu.Lock()
+		if p.recorder != nil {
+			p.recorder.Write(b[:n])
+		}
+		p.recordMu.Unlock()
+	}
+	return n, err
+}
+
+// SetRecorder copies everything received to w, or stops if w is nil
+func (p *plainConnection) SetRecorder(w io.Writer) {
+	p.recordMu.Lock()
+	p.recorder = w
+	p.recordMu.Unlock()
+}
+
+// SetWindowSize passes a new window size on to connections with a terminal
+func (p *plainConnection) SetWindowSize(cols, rows int) {
+	if conn, ok := p.Conn.(nawsConnection); ok {
+		conn.SetWindowSize(cols, rows)
+	}
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/mmcdole/runes/pkg/events"
+)
+
+func TestPlainConnection(t *testing.T) {
+	processor := events.New()
+	s := &session{events: make(chan events.Event, 64)}
+	for _, eventType := range []events.EventType{events.EventRawOutput, events.EventDisconnected} {
+		processor.Subscribe(eventType, func(e events.Event) {
+			s.events <- e
+		})
+	}
+	client, err := NewClient(processor, "", false)
+	if err != nil {
+		t.Fatal("NewClient failed:", err)
+	}
+	t.Cleanup(client.Close)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := &plainConnection{Conn: local}
+	client.do(func() { client.start(conn) })
+
+	// Without telnet processing, IAC WILL ECHO is just text
+	go remote.Write([]byte("Hello \xff\xfb\x01there\r\n"))
+	s.expectEvent(t, events.EventRawOutput, "Hello \xff\xfb\x01there")
+
+	sent := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(remote).ReadString('\n')
+		sent <- line
+	}()
+	if err := client.SendCommand("look"); err != nil {
+		t.Fatal("SendCommand failed:", err)
+	}
+	if line := <-sent; line != "look\n" {
+		t.Errorf("expected the command to be sent as is, got %q", line)
+	}
+
+	remote.Close()
+	s.expectEvent(t, events.EventDisconnected, "<nil>")
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"time"
+
+	"github.com/mmcdole/runes/pkg/events"
+)
+
+// defaultPromptTimeout is how long a partial line waits for the rest of the
+// line or a prompt marker before it is shown as a prompt
+const defaultPromptTimeout = 500 * time.Millisecond
+
+// schedulePromptFlush starts the prompt timeout if a partial line is held,
+// for servers that mark prompts with neither GA nor EOR. The timer is
+// waited on by run, so the prompt is processed on the same goroutine as
+// every other line.
+func (c *Client) schedulePromptFlush() {
+	c.promptTimer.Stop()
+	if c.promptTimeout > 0 && c.lineProcessor.Pending() {
+		c.promptTimer.Reset(c.promptTimeout)
+	}
+}
+
+// flushPrompt passes on the held partial line as a prompt
+func (c *Client) flushPrompt() {
+	c.promptTimer.Stop()
+	if prompt, ok := c.lineProcessor.Flush(); ok {
+		c.processLine(prompt, true)
+	}
+}
+
+// flushPartialLine passes on the held partial line as an ordinary line, once
+// the connection has closed and the rest won't arrive
+func (c *Client) flushPartialLine() {
+	c.promptTimer.Stop()
+	if line, ok := c.lineProcessor.Flush(); ok {
+		c.processLine(line, false)
+	}
+}
+
+func (c *Client) handleSetPromptTimeout(e events.Event) {
+	timeout, ok := e.Data.(time.Duration)
+	if !ok || timeout < 0 {
+		return
+	}
+	c.promptTimeout = timeout
+	c.schedulePromptFlush()
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"github.com/mmcdole/runes/pkg/events"
+	"github.com/mmcdole/runes/pkg/protocol/msp"
+	"github.com/mmcdole/runes/pkg/protocol/mxp"
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+)
+
+// gmcpConnection is implemented by connections that can carry GMCP
+type gmcpConnection interface {
+	SendGMCP(pkg string, data []byte) error
+	SetGMCPSupports(modules []string)
+}
+
+// msdpConnection is implemented by connections that can carry MSDP
+type msdpConnection interface {
+	SendMSDP(variable string, values ...string) error
+}
+
+// environConnection is implemented by connections that report variables
+// with NEW-ENVIRON
+type environConnection interface {
+	SetEnvironVar(name, value string)
+}
+
+// telnetConnection is implemented by connections that can send raw telnet
+// subnegotiations and negotiate arbitrary options
+type telnetConnection interface {
+	SendSubnegotiation(option byte, data []byte) error
+	EnableLocal(option byte) error
+	DisableLocal(option byte) error
+	EnableRemote(option byte) error
+	DisableRemote(option byte) error
+}
+
+// handleTelnetEvent passes protocol events from the connection to the
+// client's goroutine. It runs on the read loop goroutine, or on the
+// client's own for option changes requested by scripts.
+func (c *Client) handleTelnetEvent(e telnet.TelnetEvent) {
+	c.post(func() { c.telnetEvent(e) })
+}
+
+// telnetEvent forwards a protocol event to the event system
+func (c *Client) telnetEvent(e telnet.TelnetEvent) {
+	switch e := e.(type) {
+	case telnet.NegotiationEvent:
+		c.events.Emit(events.Event{
+			Type: events.EventTelnetNegotiation,
+			Data: struct {
+				Command string
+				Option  byte
+			}{telnet.CommandName(e.Command), e.Option},
+		})
+	case telnet.SubnegotiationEvent:
+		c.events.Emit(events.Event{
+			Type: events.EventTelnetSubneg,
+			Data: struct {
+				Option byte
+				Data   []byte
+			}{e.Option, e.Data},
+		})
+	case telnet.GMCPEvent:
+		c.events.Emit(events.Event{
+			Type: events.EventGMCP,
+			Data: struct {
+				Package string
+				Data    []byte
+			}{e.Package, e.Data},
+		})
+	case telnet.MSDPEvent:
+		c.events.Emit(events.Event{
+			Type: events.EventMSDP,
+			Data: struct {
+				Variable string
+				Value    interface{}
+			}{e.Variable, e.Value},
+		})
+	case telnet.MXPEvent:
+		if !e.Enabled {
+			c.mxp = nil
+		} else if c.mxp == nil {
+			c.mxp = mxp.New(telnet.ClientName, telnet.ClientVersion)
+		}
+	case telnet.MSPEvent:
+		c.msp = e.Enabled
+	case telnet.EchoEvent:
+		c.setServerEcho(e.Enabled)
+	case telnet.CharsetEvent:
+		c.emitEncoding(e.Encoding)
+	case telnet.PromptEvent:
+		c.flushPrompt()
+	case telnet.MSSPEvent:
+		c.events.Emit(events.Event{
+			Type: events.EventMSSP,
+			Data: e.Variables,
+		})
+	}
+}
+
+// emitMSP passes a sound or music trigger found in the output to scripts
+func (c *Client) emitMSP(trigger msp.Trigger) {
+	c.events.Emit(events.Event{
+		Type: events.EventMSP,
+		Data: struct {
+			Music    bool
+			File     string
+			Stop     bool
+			Volume   int
+			Loops    int
+			Priority int
+			Continue bool
+			Type     string
+			URL      string
+		}{trigger.Music, trigger.File, trigger.Stop, trigger.Volume, trigger.Loops,
+			trigger.Priority, trigger.Continue, trigger.Type, trigger.URL},
+	})
+}
+
+func (c *Client) handleGMCPSend(e events.Event) {
+	data, ok := e.Data.(struct {
+		Package string
+		Data    []byte
+	})
+	if !ok {
+		return
+	}
+	if conn, ok := c.current().(gmcpConnection); ok {
+		conn.SendGMCP(data.Package, data.Data)
+	}
+}
+
+func (c *Client) handleGMCPSupports(e events.Event) {
+	modules, ok := e.Data.([]string)
+	if !ok {
+		return
+	}
+	c.gmcpSupports = modules
+	if conn, ok := c.current().(gmcpConnection); ok {
+		conn.SetGMCPSupports(modules)
+	}
+}
+
+func (c *Client) handleMSDPSend(e events.Event) {
+	data, ok := e.Data.(struct {
+		Variable string
+		Values   []string
+	})
+	if !ok {
+		return
+	}
+	if conn, ok := c.current().(msdpConnection); ok {
+		conn.SendMSDP(data.Variable, data.Values...)
+	}
+}
+
+func (c *Client) handleTelnetSend(e events.Event) {
+	data, ok := e.Data.(struct {
+		Option byte
+		Data   []byte
+	})
+	if !ok {
+		return
+	}
+	if conn, ok := c.current().(telnetConnection); ok {
+		conn.SendSubnegotiation(data.Option, data.Data)
+	}
+}
+
+func (c *Client) handleTelnetOption(e events.Event) {
+	data, ok := e.Data.(struct {
+		Option byte
+		Local  bool
+		Enable bool
+	})
+	if !ok {
+		return
+	}
+	conn, ok := c.current().(telnetConnection)
+	if !ok {
+		return
+	}
+	switch {
+	case data.Local && data.Enable:
+		conn.EnableLocal(data.Option)
+	case data.Local:
+		conn.DisableLocal(data.Option)
+	case data.Enable:
+		conn.EnableRemote(data.Option)
+	default:
+		conn.DisableRemote(data.Option)
+	}
+}
+
+// handleSetEnviron sets a custom NEW-ENVIRON variable for this and future
+// connections
+func (c *Client) handleSetEnviron(e events.Event) {
+	data, ok := e.Data.(struct {
+		Name  string
+		Value string
+	})
+	if !ok {
+		return
+	}
+	if c.environ == nil {
+		c.environ = make(map[string]string)
+	}
+	c.environ[data.Name] = data.Value
+	if conn, ok := c.current().(environConnection); ok {
+		conn.SetEnvironVar(data.Name, data.Value)
+	}
+}
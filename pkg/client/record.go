@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/mmcdole/runes/pkg/events"
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+	"github.com/mmcdole/runes/pkg/ttyrec"
+)
+
+// recording is a session being recorded to a ttyrec file
+type recording struct {
+	path string
+	file *os.File
+	w    *ttyrec.Writer
+}
+
+// recorderConnection is implemented by connections that can record the raw
+// data they receive
+type recorderConnection interface {
+	SetRecorder(w io.Writer)
+}
+
+// StartRecording records everything received from the server, telnet
+// commands included, to a ttyrec file at path. Recording carries on across
+// reconnects until StopRecording is called.
+func (c *Client) StartRecording(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	rec := &recording{path: path, file: file, w: ttyrec.NewWriter(file)}
+
+	c.recordMu.Lock()
+	old := c.recording
+	c.recording = rec
+	c.recordMu.Unlock()
+
+	if conn, ok := c.current().(recorderConnection); ok {
+		conn.SetRecorder(rec.w)
+	}
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// StopRecording stops recording and closes the file, returning the path it
+// was saved to. The path is empty if there was no recording.
+func (c *Client) StopRecording() (string, error) {
+	c.recordMu.Lock()
+	rec := c.recording
+	c.recording = nil
+	c.recordMu.Unlock()
+
+	if rec == nil {
+		return "", nil
+	}
+	if conn, ok := c.current().(recorderConnection); ok {
+		conn.SetRecorder(nil)
+	}
+	return rec.path, rec.close()
+}
+
+// recordConnection starts recording a new connection if a recording is in
+// progress
+func (c *Client) recordConnection(conn Connection) {
+	c.recordMu.Lock()
+	defer c.recordMu.Unlock()
+
+	if rc, ok := conn.(recorderConnection); ok && c.recording != nil {
+		rc.SetRecorder(c.recording.w)
+	}
+}
+
+// close closes the file, returning the first error from writing or closing it
+func (r *recording) close() error {
+	err := r.w.Err()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Replay plays back a recording made with StartRecording as if it were
+// arriving from a server, speed times as fast as it was recorded or without
+// pauses if speed is zero. The data goes through telnet processing, scripts
+// and the display like a live session, and anything sent in reply is
+// discarded. It returns once the whole recording has been processed.
+func (c *Client) Replay(r io.Reader, speed float64) error {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	// Replies to the recorded server go nowhere
+	go io.Copy(io.Discard, remote)
+
+	telnetConn := telnet.FromConn(local, c.debug)
+	c.do(func() { c.attach(telnetConn) })
+
+	played := make(chan error, 1)
+	go func() {
+		played <- ttyrec.Play(context.Background(), remote, ttyrec.NewReader(r), speed)
+		remote.Close()
+	}()
+	c.readLoop(telnetConn)
+	return <-played
+}
+
+func (c *Client) handleRecordStart(e events.Event) {
+	path, ok := e.Data.(string)
+	if !ok {
+		return
+	}
+	msg := fmt.Sprintf("Recording to %s", path)
+	if err := c.StartRecording(path); err != nil {
+		msg = fmt.Sprintf("Failed to start recording: %v", err)
+	}
+	c.events.Emit(events.Event{
+		Type: events.EventRawOutput,
+		Data: msg,
+	})
+}
+
+func (c *Client) handleRecordStop(e events.Event) {
+	path, err := c.StopRecording()
+	var msg string
+	switch {
+	case path == "":
+		msg = "Not recording"
+	case err != nil:
+		msg = fmt.Sprintf("Recording to %s failed: %v", path, err)
+	default:
+		msg = fmt.Sprintf("Recording saved to %s", path)
+	}
+	c.events.Emit(events.Event{
+		Type: events.EventRawOutput,
+		Data: msg,
+	})
+}
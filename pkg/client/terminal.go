@@ -0,0 +1,32 @@
+package client
+
+import (
+	"os"
+	"strings"
+
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+)
+
+// terminalType returns the terminal type and MTTS flags to report to the
+// server, based on the environment the client is running in
+func terminalType() (string, int) {
+	name := os.Getenv("TERM")
+	if name == "" || name == "dumb" {
+		name = "ANSI"
+	}
+
+	// Output is always written as UTF-8 with ANSI colours
+	flags := telnet.MTTSANSI | telnet.MTTSUTF8
+	if strings.HasPrefix(name, "xterm") || strings.HasPrefix(name, "vt1") {
+		flags |= telnet.MTTSVT100
+	}
+
+	colorTerm := strings.ToLower(os.Getenv("COLORTERM"))
+	if colorTerm == "truecolor" || colorTerm == "24bit" {
+		flags |= telnet.MTTS256Colors | telnet.MTTSTrueColor
+	} else if strings.Contains(name, "256color") {
+		flags |= telnet.MTTS256Colors
+	}
+
+	return name, flags
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"crypto/tls"
+)
+
+// tlsConnection is implemented by connections that can run over TLS
+type tlsConnection interface {
+	TLSState() (tls.ConnectionState, bool)
+}
+
+// connectionStatus describes the current connection, including the TLS
+// version and cipher suite when it is encrypted
+func (c *Client) connectionStatus(host string, port int) struct {
+	Host       string
+	Port       int
+	TLSVersion string
+	TLSCipher  string
+} {
+	status := struct {
+		Host       string
+		Port       int
+		TLSVersion string
+		TLSCipher  string
+	}{Host: host, Port: port}
+
+	if conn, ok := c.current().(tlsConnection); ok {
+		if state, ok := conn.TLSState(); ok {
+			status.TLSVersion = tls.VersionName(state.Version)
+			status.TLSCipher = tls.CipherSuiteName(state.CipherSuite)
+		}
+	}
+	return status
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"os"
+
+	"golang.org/x/term"
+
+	"github.com/mmcdole/runes/pkg/events"
+)
+
+// Size reported when output isn't a terminal
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// nawsConnection is implemented by connections that can report the window
+// size to the server
+type nawsConnection interface {
+	SetWindowSize(cols, rows int)
+}
+
+// terminalSize returns the size of the terminal attached to stdout
+func terminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return defaultWidth, defaultHeight
+	}
+	return width, height
+}
+
+// windowSize returns the size to report to the server: the size set from
+// scripts if there is one, otherwise the display's
+func (c *Client) windowSize() (int, int) {
+	if c.sizeOverride[0] > 0 && c.sizeOverride[1] > 0 {
+		return c.sizeOverride[0], c.sizeOverride[1]
+	}
+	return c.display.Size()
+}
+
+// updateWindowSize reports the current window size to the server and
+// scripts
+func (c *Client) updateWindowSize() {
+	cols, rows := c.windowSize()
+	if conn, ok := c.current().(nawsConnection); ok {
+		conn.SetWindowSize(cols, rows)
+	}
+	c.events.Emit(events.Event{
+		Type: events.EventWindowSize,
+		Data: struct {
+			Cols int
+			Rows int
+		}{cols, rows},
+	})
+}
+
+// handleSetWindowSize overrides the reported size, or follows the terminal
+// again when given a zero size
+func (c *Client) handleSetWindowSize(e events.Event) {
+	data, ok := e.Data.(struct {
+		Cols int
+		Rows int
+	})
+	if !ok {
+		return
+	}
+	c.sizeOverride = [2]int{data.Cols, data.Rows}
+	c.updateWindowSize()
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package client
+
+// watchResize does nothing on platforms without SIGWINCH; the size is read
+// once at startup.
+func (c *Client) watchResize() {}
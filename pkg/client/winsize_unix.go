@@ -0,0 +1,22 @@
+//go:build unix
+
+package client
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchResize updates the display size whenever the terminal is resized.
+// The update is posted to the client's goroutine, as it reaches the
+// connection and scripts.
+func (c *Client) watchResize() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	for range sigs {
+		c.post(func() {
+			c.display.SetSize(terminalSize())
+		})
+	}
+}
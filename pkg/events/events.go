@@ -10,12 +10,21 @@ const (
 	// Raw events (from client/mud)
 	EventRawInput  EventType = "raw_input"  // From client
 	EventRawOutput EventType = "raw_output" // From MUD
+	EventPrompt    EventType = "prompt"     // Prompt from MUD, ended by GA/EOR or a timeout
 
 	// Connection events
-	EventConnect      EventType = "connect"      // Request to connect
-	EventConnected    EventType = "connected"    // Connection established
-	EventDisconnect   EventType = "disconnect"   // Request to disconnect
-	EventDisconnected EventType = "disconnected" // Connection closed
+	EventConnect           EventType = "connect"             // Request to connect
+	EventConnectProgress   EventType = "connect_progress"    // Connection attempt is resolving, connecting or failed
+	EventConnected         EventType = "connected"           // Connection established
+	EventDisconnect        EventType = "disconnect"          // Request to disconnect or abandon a connection attempt
+	EventDisconnected      EventType = "disconnected"        // Connection closed
+	EventSetProxy          EventType = "set_proxy"           // Set the default proxy for connections
+	EventSetConnectTimeout EventType = "set_connect_timeout" // Set how long connecting may take
+	EventSetIdleTimeout    EventType = "set_idle_timeout"    // Set how long a silent connection stays open
+	EventSetKeepalive      EventType = "set_keepalive"       // Set the TCP keepalive period for new connections
+	EventSetProbe          EventType = "set_probe"           // Set how often a silent connection is probed with IAC NOP or AYT
+	EventSetIdleEvent      EventType = "set_idle_event"      // Set how long without input or output before EventIdle
+	EventIdle              EventType = "idle"                // Nothing sent or received for the idle event period
 
 	// Processed events (from LuaEngine)
 	EventCommand      EventType = "command"
@@ -25,10 +34,78 @@ const (
 	EventListBuffers  EventType = "list_buffers"
 	EventSwitchBuffer EventType = "switch_buffer"
 
+	// Protocol events
+	EventGMCP         EventType = "gmcp"          // GMCP message from the MUD
+	EventGMCPSend     EventType = "gmcp_send"     // GMCP message to send to the MUD
+	EventGMCPSupports EventType = "gmcp_supports" // Set the GMCP modules to announce
+	EventMSDP         EventType = "msdp"          // MSDP variable from the MUD
+	EventMSDPSend     EventType = "msdp_send"     // MSDP request to send to the MUD
+	EventMSSP         EventType = "mssp"          // MSSP server status from the MUD
+	EventMXPLink      EventType = "mxp_link"      // MXP link in the last line of output
+	EventMSP          EventType = "msp"           // MSP sound or music trigger from the MUD
+
+	// Compression events
+	EventCompressionStats  EventType = "compression_stats"   // Request the connection's MCCP statistics
+	EventSetCompressOutput EventType = "set_compress_output" // Offer MCCP3 (true) or refuse it (false) on this and future connections
+
+	// Raw telnet events
+	EventTelnetNegotiation EventType = "telnet_negotiation" // WILL/WONT/DO/DONT from the MUD
+	EventTelnetSubneg      EventType = "telnet_subneg"      // Subnegotiation from the MUD
+	EventTelnetSend        EventType = "telnet_send"        // Subnegotiation to send to the MUD
+	EventTelnetOption      EventType = "telnet_option"      // Enable or disable an option
+	EventSetEnviron        EventType = "set_environ"        // Set a custom NEW-ENVIRON variable
+
+	// Window size events
+	EventWindowSize    EventType = "window_size"     // Size reported to the MUD changed
+	EventSetWindowSize EventType = "set_window_size" // Override the reported size
+
+	// Text encoding events
+	EventEncoding    EventType = "encoding"     // Encoding of text on the connection changed
+	EventSetEncoding EventType = "set_encoding" // Set the encoding for this and future connections
+
+	// Input echo events
+	EventEcho EventType = "echo" // Server took over (true) or handed back (false) echoing of input
+
+	// Prompt detection events
+	EventSetPromptTimeout EventType = "set_prompt_timeout" // Set how long partial lines wait before becoming prompts
+
+	// Session recording events
+	EventRecordStart EventType = "record_start" // Start recording the raw stream from the MUD to a file
+	EventRecordStop  EventType = "record_stop"  // Stop recording
+
 	// Client lifecycle events
 	EventQuit EventType = "quit" // Request to quit the client
 )
 
+// ConnectRequest is the data of an EventConnect. URL, if set, names the
+// server in place of Host and Port.
+type ConnectRequest struct {
+	Host            string
+	Port            int
+	TLS             bool     // Connect over TLS
+	CAFile          string   // PEM file with the CAs to trust instead of the system roots
+	Fingerprints    []string // SHA-256 fingerprints of certificates to accept
+	Insecure        bool     // Skip the certificate check
+	Proxy           string   // Proxy to connect through, empty for the default
+	URL             string   // Server URL such as wss://example.com/mud or exec:command
+	Identity        string   // SSH private key file
+	InsecureHostKey bool     // Skip the SSH host key check
+	Plain           bool     // The exec: command's output is plain text rather than telnet
+}
+
+// CompressionStats is the data of an EventCompressionStats, a pointer the
+// client fills in with the MCCP statistics of the current connection
+type CompressionStats struct {
+	Connected               bool // Connected to a telnet server, otherwise the rest is unset
+	Active                  bool // Data from the server is compressed (MCCP2)
+	Streams                 int64
+	CompressedBytes         int64 // Received from the server
+	DecompressedBytes       int64 // Inflated from CompressedBytes
+	OutboundActive          bool  // Data sent to the server is compressed (MCCP3)
+	OutboundBytes           int64 // Sent while compressing
+	OutboundCompressedBytes int64 // Deflated from OutboundBytes
+}
+
 type Event struct {
 	Type EventType
 	Data interface{}
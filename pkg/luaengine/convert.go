@@ -0,0 +1,121 @@
+package luaengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// toLuaValue converts a decoded JSON value into the equivalent Lua value.
+// Objects become tables keyed by name and arrays become sequences.
+func toLuaValue(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case json.Number:
+		f, _ := v.Float64()
+		return lua.LNumber(f)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		tbl := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			tbl.Append(toLuaValue(L, item))
+		}
+		return tbl
+	case map[string]interface{}:
+		tbl := L.CreateTable(0, len(v))
+		for key, item := range v {
+			tbl.RawSetString(key, toLuaValue(L, item))
+		}
+		return tbl
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+// fromLuaValue converts a Lua value into a value that can be encoded as JSON.
+// Tables whose keys are exactly 1..n become arrays, other tables objects.
+func fromLuaValue(value lua.LValue) interface{} {
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		return fromLuaTable(v)
+	default:
+		return v.String()
+	}
+}
+
+func fromLuaTable(tbl *lua.LTable) interface{} {
+	length := tbl.Len()
+	count := 0
+	tbl.ForEach(func(lua.LValue, lua.LValue) { count++ })
+
+	if count > 0 && count == length {
+		arr := make([]interface{}, 0, length)
+		for i := 1; i <= length; i++ {
+			arr = append(arr, fromLuaValue(tbl.RawGetInt(i)))
+		}
+		return arr
+	}
+
+	obj := make(map[string]interface{}, count)
+	tbl.ForEach(func(key, item lua.LValue) {
+		obj[key.String()] = fromLuaValue(item)
+	})
+	return obj
+}
+
+// jsonToLua decodes a JSON document into a Lua value. Empty input decodes
+// to nil.
+func jsonToLua(L *lua.LState, data []byte) (lua.LValue, error) {
+	if len(data) == 0 {
+		return lua.LNil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return lua.LNil, err
+	}
+	return toLuaValue(L, value), nil
+}
+
+// luaToJSON encodes a Lua value as JSON
+func luaToJSON(value lua.LValue) ([]byte, error) {
+	return json.Marshal(fromLuaValue(value))
+}
+
+// toInterfaces converts a string slice for use with toLuaValue
+func toInterfaces(list []string) []interface{} {
+	values := make([]interface{}, len(list))
+	for i, s := range list {
+		values[i] = s
+	}
+	return values
+}
+
+// luaStringList returns the string values of a Lua sequence in order
+func luaStringList(tbl *lua.LTable) []string {
+	list := make([]string, 0, tbl.Len())
+	for i := 1; i <= tbl.Len(); i++ {
+		list = append(list, tbl.RawGetInt(i).String())
+	}
+	return list
+}
+
+// luaSeconds converts a number of seconds from a script into a duration
+func luaSeconds(n lua.LNumber) time.Duration {
+	return time.Duration(float64(n) * float64(time.Second))
+}
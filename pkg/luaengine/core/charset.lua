@@ -0,0 +1,18 @@
+-- core/charset.lua
+
+charset = {}  -- Declare global charset table
+local current = "UTF-8"
+
+--- Returns the encoding of text on the connection
+function charset.current()
+    return current
+end
+
+--- Sets the encoding for this and future connections, e.g. "CP437"
+function charset.set(name)
+    runes.set_encoding(name)
+end
+
+events.add("encoding", function(data)
+    current = data
+end)
@@ -3,13 +3,27 @@
 -- Command syntax definitions
 local commands = {
     connect = {
-        syntax = "/connect <host> <port>",
-        description = "Connect to a MUD server",
-        help = "Examples:\n  /connect example.com 4000\n  /connect localhost 4000"
+        syntax = "/connect [--tls] [--ca-file <file>] [--fingerprint <sha256>] [--insecure] [--identity <key>] [--insecure-host-key] [--plain] [--proxy <url>] <host> <port> | <url>",
+        description = "Connect to a MUD server, optionally over TLS or through a proxy",
+        help = "A tls:// prefix on the host also selects TLS. A telnet://, ws:// or wss:// URL may be\n" ..
+            "given instead of the host and port, the last two for servers behind WebSocket gateways.\n" ..
+            "Certificates are checked against the system roots unless a CA file or pinned\n" ..
+            "fingerprints are given; --insecure skips the check.\n" ..
+            "ssh://user@host logs in with the SSH agent's keys, ~/.ssh/id_* or the --identity key,\n" ..
+            "checking the server against ~/.ssh/known_hosts unless --insecure-host-key is given.\n" ..
+            "exec:<command> runs a command, such as a local game or a bastion hop, and talks to it\n" ..
+            "over its input and output; --plain turns off telnet processing of its output.\n" ..
+            "Proxies are given as socks5://[user:pass@]host[:port] or http://[user:pass@]host[:port].\n" ..
+            "Connecting happens in the background; /disconnect stops it.\n" ..
+            "Examples:\n  /connect example.com 4000\n  /connect --tls example.com 4443\n" ..
+            "  /connect tls://example.com:4443\n  /connect --fingerprint 3f:a2:...:9c example.com 4443\n" ..
+            "  /connect --proxy socks5://127.0.0.1:9050 example.com 4000\n" ..
+            "  /connect wss://example.com/mud\n  /connect --identity ~/.ssh/mud_key ssh://player@example.com\n" ..
+            "  /connect exec:\"ssh jumpbox nc mud.example.com 4000\""
     },
     disconnect = {
         syntax = "/disconnect",
-        description = "Disconnect from the current server"
+        description = "Disconnect from the current server, or stop connecting"
     },
     quit = {
         syntax = "/quit",
@@ -36,6 +50,37 @@ local commands = {
     triggers = {
         syntax = "/triggers",
         description = "List all defined triggers"
+    },
+    mssp = {
+        syntax = "/mssp",
+        description = "Show the status information sent by the server (MSSP)"
+    },
+    links = {
+        syntax = "/links",
+        description = "List recent MXP links"
+    },
+    link = {
+        syntax = "/link <id> [choice]",
+        description = "Run the command of an MXP link",
+        help = "Examples:\n  /link 12\n  /link 12 2"
+    },
+    encoding = {
+        syntax = "/encoding [name]",
+        description = "Show or set the text encoding of the connection",
+        help = "Supported: UTF-8, ISO-8859-1, CP437, WINDOWS-1252\nExamples:\n  /encoding\n  /encoding cp437"
+    },
+    mccp = {
+        syntax = "/mccp [on|off]",
+        description = "Show how much the connection is compressed (MCCP)",
+        help = "on and off offer or refuse compression of what you send (MCCP3) on this and later\n" ..
+            "connections.\nExamples:\n  /mccp\n  /mccp off"
+    },
+    record = {
+        syntax = "/record <start <file>|stop>",
+        description = "Record the raw data from the server to a file",
+        help = "Recordings are in ttyrec format, telnet commands included, and carry on across\n" ..
+            "reconnects. Play one back through your scripts with: runes replay <file>\n" ..
+            "Examples:\n  /record start session.rec\n  /record stop"
     }
 }
 
@@ -75,12 +120,97 @@ local function show_syntax(cmd_name)
     runes.output(C_GREEN .. "Syntax: " .. cmd.syntax .. C_RESET)
 end
 
+-- Checks that each insecure option applies to the connection: --insecure
+-- to TLS and --insecure-host-key to SSH, reporting an error if not
+local function check_insecure(options, scheme)
+    if options.insecure and not options.tls then
+        runes.output(C_RED .. "Error: --insecure needs --tls or a tls:// or wss:// address" .. C_RESET)
+        return false
+    end
+    if options.insecure_host_key and scheme ~= "ssh" then
+        runes.output(C_RED .. "Error: --insecure-host-key only applies to ssh:// addresses" .. C_RESET)
+        return false
+    end
+    return true
+end
+
 -- Connection management
 alias.add("^/connect%s*(.*)$", function(matches, line)
-    local args = matches[1]
-    local host, port = string.match(args, "^(%S+)%s+(%d+)$")
-    
-    if not host or not port then
+    -- An exec: command runs to the end of the line
+    local rest, command = string.match(matches[1], "^(.-)%s*(exec:.*)$")
+    local args = {}
+    for word in string.gmatch(rest or matches[1], "%S+") do
+        table.insert(args, word)
+    end
+
+    local options = { fingerprints = {} }
+    local positional = {}
+    local i = 1
+    while i <= #args do
+        local arg = args[i]
+        if arg == "--tls" then
+            options.tls = true
+        elseif arg == "--insecure" then
+            options.insecure = true
+        elseif arg == "--ca-file" and args[i + 1] then
+            options.tls = true
+            options.ca_file = args[i + 1]
+            i = i + 1
+        elseif arg == "--fingerprint" and args[i + 1] then
+            options.tls = true
+            table.insert(options.fingerprints, args[i + 1])
+            i = i + 1
+        elseif arg == "--identity" and args[i + 1] then
+            options.identity = args[i + 1]
+            i = i + 1
+        elseif arg == "--insecure-host-key" then
+            options.insecure_host_key = true
+        elseif arg == "--plain" then
+            options.plain = true
+        elseif arg == "--proxy" and args[i + 1] then
+            options.proxy = args[i + 1]
+            i = i + 1
+        elseif arg:sub(1, 2) == "--" then
+            runes.output(C_RED .. "Error: Unknown option " .. arg .. C_RESET)
+            show_syntax("connect")
+            return
+        else
+            table.insert(positional, arg)
+        end
+        i = i + 1
+    end
+
+    if command then
+        if #positional > 0 or not check_insecure(options, "exec") then
+            show_syntax("connect")
+            return
+        end
+        runes.connect(command, options)
+        return
+    end
+
+    local host, port = positional[1], positional[2]
+    if host and host:match("^%a[%w+.-]*://") and host:sub(1, 6) ~= "tls://" then
+        -- Other schemes, such as ws:// and wss://, are passed on as URLs
+        local scheme = host:match("^(%a[%w+.-]*)://"):lower()
+        if scheme == "wss" then
+            options.tls = true
+        end
+        if #positional > 1 or not check_insecure(options, scheme) then
+            show_syntax("connect")
+            return
+        end
+        runes.connect(host, options)
+        return
+    elseif host and host:sub(1, 6) == "tls://" then
+        options.tls = true
+        host = host:sub(7)
+        if not port then
+            host, port = string.match(host, "^(.+):(%d+)$")
+        end
+    end
+
+    if #positional > 2 or not host or not port or not tonumber(port) or not check_insecure(options) then
         show_syntax("connect")
         return
     end
@@ -92,7 +222,7 @@ alias.add("^/connect%s*(.*)$", function(matches, line)
         return
     end
     
-    runes.connect(host, port)
+    runes.connect(host, port, options)
 end)
 
 alias.add("^/disconnect$", function(matches, line)
@@ -136,13 +266,19 @@ alias.add("^/help%s*(.*)$", function(matches, line)
     runes.output(C_GREEN .. [[
 Available commands:
   /help [command] - Show help for all commands or a specific command
-  /connect        - Connect to a MUD server: /connect <host> <port>
-  /disconnect     - Disconnect from server
+  /connect        - Connect to a MUD server: /connect [--tls] [--proxy <url>] <host> <port>
+  /disconnect     - Disconnect from server, or stop connecting
   /buffer list    - List all buffers
   /buffer switch  - Switch to a different buffer
   /load           - Load a script file: /load <path>
   /aliases        - List all defined aliases
   /triggers       - List all defined triggers
+  /mssp           - Show server status information
+  /links          - List recent MXP links
+  /link           - Run an MXP link: /link <id> [choice]
+  /encoding       - Show or set the text encoding: /encoding [name]
+  /mccp           - Show compression statistics: /mccp [on|off]
+  /record         - Record the session: /record <start <file>|stop>
   /quit           - Quit the client
 
 Type /help <command> for detailed help on a specific command.
@@ -199,7 +335,132 @@ alias.add("^/triggers$", function(matches, line)
     end
 end)
 
+-- Server status command
+alias.add("^/mssp$", function(matches, line)
+    local names = {}
+    for name, _ in pairs(mssp.variables) do
+        table.insert(names, name)
+    end
+
+    if #names == 0 then
+        runes.output(C_YELLOW .. "The server has not sent any MSSP information" .. C_RESET)
+        return
+    end
+
+    table.sort(names)
+    runes.output(C_GREEN .. "=== Server Status ===" .. C_RESET)
+    for _, name in ipairs(names) do
+        runes.output(string.format("%s%-20s%s %s",
+            C_YELLOW,
+            name,
+            C_RESET,
+            table.concat(mssp.variables[name], ", ")
+        ))
+    end
+end)
+
+-- MXP link commands
+alias.add("^/links$", function(matches, line)
+    local list = mxp.links()
+    if #list == 0 then
+        runes.output(C_YELLOW .. "No links received" .. C_RESET)
+        return
+    end
+
+    runes.output(C_GREEN .. "=== Links ===" .. C_RESET)
+    for _, link in ipairs(list) do
+        local target = link.url or table.concat(link.commands, " | ")
+        runes.output(string.format("%s%4d%s %s -> %s",
+            C_YELLOW,
+            link.id,
+            C_RESET,
+            link.text,
+            target
+        ))
+    end
+end)
+
+alias.add("^/link$", function(matches, line)
+    show_syntax("link")
+end)
+
+alias.add("^/link%s+(.*)$", function(matches, line)
+    local id, choice = string.match(matches[1], "^(%d+)%s*(%d*)$")
+    if not id then
+        show_syntax("link")
+        return
+    end
+
+    local link = mxp.get(tonumber(id))
+    if link and link.url then
+        runes.output(C_GREEN .. "Link: " .. link.url .. C_RESET)
+        return
+    end
+    if not mxp.click(tonumber(id), tonumber(choice)) then
+        runes.output(C_RED .. "Error: No such link: " .. matches[1] .. C_RESET)
+    end
+end)
+
 -- Quit command
 alias.add("^/quit$", function(matches, line)
     runes.quit()
 end)
+
+-- Text encoding command
+alias.add("^/encoding%s*(.*)$", function(matches, line)
+    local name = matches[1]
+    if name == "" then
+        runes.output(C_GREEN .. "Encoding: " .. charset.current() .. C_RESET)
+        return
+    end
+    charset.set(name)
+end)
+
+-- Compression statistics command
+local function on_off(active)
+    if active then return "on" end
+    return "off"
+end
+
+local function show_compression()
+    local stats = runes.compression_stats()
+    if not stats then
+        runes.output("Not connected to a telnet server")
+        return
+    end
+    local ratio = 0
+    if stats.compressed > 0 then
+        ratio = stats.decompressed / stats.compressed
+    end
+    runes.output(string.format("MCCP2 (from server): %s, %d streams, %d bytes received inflated to %d (%.1fx)",
+        on_off(stats.active), stats.streams, stats.compressed, stats.decompressed, ratio))
+    runes.output(string.format("MCCP3 (to server): %s, %d bytes sent deflated to %d",
+        on_off(stats.outbound_active), stats.outbound, stats.outbound_compressed))
+end
+
+alias.add("^/mccp%s*(.*)$", function(matches, line)
+    local arg = matches[1]
+    if arg == "" then
+        show_compression()
+    elseif arg == "on" or arg == "off" then
+        runes.set_compress_output(arg == "on")
+    else
+        show_syntax("mccp")
+    end
+end)
+
+-- Session recording command
+alias.add("^/record%s*(.*)$", function(matches, line)
+    local args = matches[1]
+    if args == "stop" then
+        runes.record_stop()
+        return
+    end
+
+    local file = string.match(args, "^start%s+(.+)$")
+    if not file then
+        show_syntax("record")
+        return
+    end
+    runes.record_start(file)
+end)
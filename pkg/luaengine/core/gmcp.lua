@@ -0,0 +1,63 @@
+-- core/gmcp.lua
+
+gmcp = {}  -- Latest value of every package received, e.g. gmcp.Char.Vitals
+
+-- Modules announced to the server with Core.Supports.Set
+local supports = {
+    "Char 1",
+    "Char.Skills 1",
+    "Char.Items 1",
+    "Comm.Channel 1",
+    "Room 1"
+}
+
+--- Registers a handler for a GMCP package
+-- @param package The package name, e.g. "Char.Vitals"
+-- @param callback Function called with the decoded message data
+function gmcp.on(package, callback)
+    events.add("gmcp." .. package, callback)
+end
+
+--- Sends a GMCP message to the server
+-- @param package The package name, e.g. "Core.Ping"
+-- @param data Optional table or value sent as the JSON payload
+function gmcp.send(package, data)
+    runes.gmcp_send(package, data)
+end
+
+--- Sets the modules announced to the server
+-- @param modules List of modules with versions, e.g. {"Char 1", "Room 1"}
+function gmcp.supports(modules)
+    supports = modules
+    runes.gmcp_supports(modules)
+end
+
+--- Returns the modules announced to the server
+function gmcp.supported()
+    return supports
+end
+
+-- Store a value under its dotted package path
+local function store(package, data)
+    local node = gmcp
+    local parts = {}
+    for part in package:gmatch("[^%.]+") do
+        table.insert(parts, part)
+    end
+    for i = 1, #parts - 1 do
+        if type(node[parts[i]]) ~= "table" then
+            node[parts[i]] = {}
+        end
+        node = node[parts[i]]
+    end
+    if #parts > 0 then
+        node[parts[#parts]] = data
+    end
+end
+
+events.add("gmcp", function(msg)
+    store(msg.package, msg.data)
+    events.emit("gmcp." .. msg.package, msg.data)
+end)
+
+runes.gmcp_supports(supports)
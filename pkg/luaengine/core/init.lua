@@ -2,7 +2,25 @@
 
 -- Set up event handlers
 events.add("connect", function(data)
-    runes.output("Connected to server")
+    if data.port > 0 then
+        runes.output(string.format("Connected to %s:%d", data.host, data.port))
+    else
+        -- An exec: command
+        runes.output(string.format("Connected to %s", data.host))
+    end
+    if data.tls_version then
+        runes.output(string.format("Encrypted with %s (%s)", data.tls_version, data.tls_cipher))
+    end
+end)
+
+events.add("connect_progress", function(data)
+    if data.stage == "resolving" then
+        runes.output(string.format("Looking up %s...", data.address))
+    elseif data.stage == "connecting" then
+        runes.output(string.format("Connecting to %s...", data.address))
+    elseif data.stage == "failed" then
+        runes.output(string.format("Failed to connect to %s: %s", data.address, data.reason))
+    end
 end)
 
 events.add("disconnect", function(data)
@@ -21,6 +39,11 @@ events.add("output", function(data)
     return false
 end)
 
+events.add("prompt", function(data)
+    runes.output(data)
+    return false
+end)
+
 -- Initialize message
 runes.output(C_GREEN .. "Welcome to Runes, the MUD client!" .. C_RESET)
 runes.output("Type /help for a list of available commands")
\ No newline at end of file
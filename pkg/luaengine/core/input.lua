@@ -49,6 +49,22 @@ function runes.send(commandStr)
     enqueue(commandStr)
 end
 
+-- While the server echoes input (password prompts) typed lines go straight
+-- to the server without passing through here
+local masked = false
+
+function runes.input_masked()
+    return masked
+end
+
+events.add("echo_off", function()
+    masked = true
+end)
+
+events.add("echo_on", function()
+    masked = false
+end)
+
 -- Subscribe to input events directly
 events.add("input", function(input)
     table.insert(commandQueue, input)
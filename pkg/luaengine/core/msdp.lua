@@ -0,0 +1,35 @@
+-- core/msdp.lua
+
+msdp = {}  -- Current value of every variable reported, e.g. msdp.HEALTH
+
+--- Registers a handler for changes to an MSDP variable
+-- @param variable The variable name, e.g. "HEALTH"
+-- @param callback Function called with the new value
+function msdp.on(variable, callback)
+    events.add("msdp." .. variable, callback)
+end
+
+--- Asks the server for a list, e.g. "COMMANDS" or "REPORTABLE_VARIABLES"
+function msdp.list(name)
+    runes.msdp_send("LIST", name)
+end
+
+--- Asks the server to report variables whenever they change
+function msdp.report(...)
+    runes.msdp_send("REPORT", ...)
+end
+
+--- Stops the server reporting variables
+function msdp.unreport(...)
+    runes.msdp_send("UNREPORT", ...)
+end
+
+--- Asks the server to send the current value of variables once
+function msdp.send(...)
+    runes.msdp_send("SEND", ...)
+end
+
+events.add("msdp", function(msg)
+    msdp[msg.variable] = msg.value
+    events.emit("msdp." .. msg.variable, msg.value)
+end)
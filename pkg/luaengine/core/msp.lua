@@ -0,0 +1,41 @@
+-- core/msp.lua
+
+msp = {
+    music = nil  -- Last MUSIC trigger, nil once the server stops the music
+}
+local defaultURL = nil  -- Set by a trigger of "Off" with a U= parameter
+
+--- Returns where to download a trigger's file: its own URL, or the default
+-- one the server set, followed by the file name. Nil if neither is known.
+function msp.url(trigger)
+    if trigger.stop then
+        return nil
+    end
+    local base = trigger.url or defaultURL
+    if not base then
+        return nil
+    end
+    if string.sub(base, -1) ~= "/" then
+        base = base .. "/"
+    end
+    return base .. trigger.file
+end
+
+-- Triggers are only tracked here. Scripts that want to hear them handle the
+-- "sound" and "music" events, e.g. by running a player with the file.
+local function track(trigger)
+    if trigger.stop and trigger.url then
+        defaultURL = trigger.url
+    end
+end
+
+events.add("sound", track)
+
+events.add("music", function(trigger)
+    track(trigger)
+    if trigger.stop then
+        msp.music = nil
+    else
+        msp.music = trigger
+    end
+end)
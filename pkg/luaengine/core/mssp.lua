@@ -0,0 +1,18 @@
+-- core/mssp.lua
+
+mssp = {
+    variables = {}  -- Latest server status, each variable a list of values
+}
+
+--- Returns the first value of an MSSP variable, e.g. mssp.get("PLAYERS")
+function mssp.get(name)
+    local values = mssp.variables[name]
+    if values then
+        return values[1]
+    end
+    return nil
+end
+
+events.add("mssp", function(variables)
+    mssp.variables = variables
+end)
@@ -0,0 +1,55 @@
+-- core/mxp.lua
+
+mxp = {}  -- Declare global mxp table
+local links = {}  -- Recent links, oldest first
+local nextId = 1
+local maxLinks = 100
+
+--- Returns the recent links, oldest first. Each link has an id, text,
+-- commands, hints, prompt flag and, for web links, a url. first and last
+-- give the position of the text in the line it arrived with, so
+-- line:sub(link.first, link.last) is the text.
+function mxp.links()
+    return links
+end
+
+--- Returns a link by id
+function mxp.get(id)
+    for _, link in ipairs(links) do
+        if link.id == id then
+            return link
+        end
+    end
+    return nil
+end
+
+--- Runs one of a link's commands, the first by default
+-- @return true if the link exists and has the command
+function mxp.click(id, choice)
+    local link = mxp.get(id)
+    if not link then
+        return false
+    end
+
+    local command = link.commands[choice or 1]
+    if not command then
+        return false
+    end
+
+    if link.prompt then
+        -- Prompt links are meant to be edited before sending
+        runes.output(C_YELLOW .. "Suggested command: " .. command .. C_RESET)
+        return true
+    end
+    runes.send(command)
+    return true
+end
+
+events.add("mxp_link", function(link)
+    link.id = nextId
+    nextId = nextId + 1
+    table.insert(links, link)
+    if #links > maxLinks then
+        table.remove(links, 1)
+    end
+end)
@@ -0,0 +1,20 @@
+-- core/prompt.lua
+
+prompt = {}  -- Declare global prompt table
+local last = ""
+
+--- Returns the last prompt received from the server
+function prompt.last()
+    return last
+end
+
+--- Sets how long a partial line waits for the rest of the line or a prompt
+-- marker before it is treated as a prompt. 0 waits forever.
+-- @param ms Timeout in milliseconds
+function prompt.set_timeout(ms)
+    runes.set_prompt_timeout(ms)
+end
+
+events.add("prompt", function(data)
+    last = data
+end)
@@ -0,0 +1,62 @@
+-- core/telnet.lua
+
+telnet = {}  -- Declare global telnet table
+local subneg_handlers = {}       -- Option number -> subnegotiation handlers
+local negotiation_handlers = {}  -- Option number -> negotiation handlers
+
+--- Registers a handler for subnegotiations of an option
+-- @param option Option number, 0-255
+-- @param callback Called with the payload between IAC SB <option> and IAC SE
+function telnet.on(option, callback)
+    if type(callback) ~= "function" then
+        return
+    end
+    subneg_handlers[option] = subneg_handlers[option] or {}
+    table.insert(subneg_handlers[option], callback)
+end
+
+--- Registers a handler for WILL/WONT/DO/DONT of an option
+-- @param option Option number, 0-255
+-- @param callback Called with the command name, e.g. "WILL"
+function telnet.on_negotiation(option, callback)
+    if type(callback) ~= "function" then
+        return
+    end
+    negotiation_handlers[option] = negotiation_handlers[option] or {}
+    table.insert(negotiation_handlers[option], callback)
+end
+
+--- Sends IAC SB <option> <data> IAC SE. IAC bytes in data are escaped.
+function telnet.send(option, data)
+    runes.telnet_send(option, data or "")
+end
+
+--- Asks for an option to be enabled and accepts it from now on
+-- @param side "local" for our side (WILL), "remote" for the server's (DO)
+function telnet.enable(option, side)
+    runes.telnet_option(option, side or "remote", true)
+end
+
+--- Asks for an option to be disabled and refuses it from now on
+-- @param side "local" for our side (WONT), "remote" for the server's (DONT)
+function telnet.disable(option, side)
+    runes.telnet_option(option, side or "remote", false)
+end
+
+--- Sets a custom variable reported to the server with NEW-ENVIRON (MNES).
+-- Changes are sent to the server if it has asked for the variable.
+function telnet.set_environ(name, value)
+    runes.set_environ(name, tostring(value))
+end
+
+events.add("telnet_subneg", function(data)
+    for _, callback in ipairs(subneg_handlers[data.option] or {}) do
+        callback(data.data)
+    end
+end)
+
+events.add("telnet_negotiation", function(data)
+    for _, callback in ipairs(negotiation_handlers[data.option] or {}) do
+        callback(data.command)
+    end
+end)
@@ -13,7 +13,23 @@ function trigger.add(name, pattern, callback)
         name = name,
         pattern = pattern,
         callback = callback,
-        enabled = true
+        enabled = true,
+        prompt = false
+    })
+end
+
+-- Add a trigger that matches prompts instead of lines
+function trigger.add_prompt(name, pattern, callback)
+    if type(callback) ~= "function" then
+        return
+    end
+
+    table.insert(triggers, {
+        name = name,
+        pattern = pattern,
+        callback = callback,
+        enabled = true,
+        prompt = true
     })
 end
 
@@ -53,16 +69,17 @@ function trigger.list()
         table.insert(result, {
             name = t.name,
             pattern = t.pattern,
-            enabled = t.enabled
+            enabled = t.enabled,
+            prompt = t.prompt
         })
     end
     return result
 end
 
--- Process output against triggers
-local function process(output)
+-- Process output against line or prompt triggers
+local function process(output, prompt)
     for _, trigger in ipairs(triggers) do
-        if trigger.enabled then
+        if trigger.enabled and trigger.prompt == prompt then
             local matches = {string.match(output, trigger.pattern)}
             if matches[1] then
                 runes.debug(string.format("Trigger %q matched: %s", trigger.name, output))
@@ -72,5 +89,10 @@ local function process(output)
     end
 end
 
--- Subscribe to the 'output' event
-events.add("output", process)
+-- Subscribe to the 'output' and 'prompt' events
+events.add("output", function(output)
+    process(output, false)
+end)
+events.add("prompt", function(output)
+    process(output, true)
+end)
@@ -0,0 +1,24 @@
+-- core/window.lua
+
+window = {}  -- Declare global window table
+local size = { cols = 80, rows = 24 }
+
+--- Returns the window size reported to the server
+-- @return cols, rows
+function window.size()
+    return size.cols, size.rows
+end
+
+--- Overrides the window size reported to the server, e.g. for headless bots
+function window.set_size(cols, rows)
+    runes.set_window_size(cols, rows)
+end
+
+--- Goes back to reporting the real terminal size
+function window.reset_size()
+    runes.set_window_size(0, 0)
+end
+
+events.add("window_size", function(data)
+    size = data
+end)
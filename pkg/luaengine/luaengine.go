@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/mmcdole/runes/pkg/events"
 	lua "github.com/yuin/gopher-lua"
@@ -39,6 +40,20 @@ func New(userScriptDir string, eventSystem *events.EventProcessor) *LuaEngine {
 	// Subscribe to raw events that need Lua processing
 	eventSystem.Subscribe(events.EventRawInput, engine.handleRawInput)
 	eventSystem.Subscribe(events.EventRawOutput, engine.handleRawOutput)
+	eventSystem.Subscribe(events.EventConnectProgress, engine.handleConnectProgress)
+	eventSystem.Subscribe(events.EventConnected, engine.handleConnected)
+	eventSystem.Subscribe(events.EventIdle, engine.handleIdle)
+	eventSystem.Subscribe(events.EventPrompt, engine.handlePrompt)
+	eventSystem.Subscribe(events.EventGMCP, engine.handleGMCP)
+	eventSystem.Subscribe(events.EventMSDP, engine.handleMSDP)
+	eventSystem.Subscribe(events.EventMSSP, engine.handleMSSP)
+	eventSystem.Subscribe(events.EventMXPLink, engine.handleMXPLink)
+	eventSystem.Subscribe(events.EventMSP, engine.handleMSP)
+	eventSystem.Subscribe(events.EventWindowSize, engine.handleWindowSize)
+	eventSystem.Subscribe(events.EventEncoding, engine.handleEncoding)
+	eventSystem.Subscribe(events.EventEcho, engine.handleEcho)
+	eventSystem.Subscribe(events.EventTelnetNegotiation, engine.handleTelnetNegotiation)
+	eventSystem.Subscribe(events.EventTelnetSubneg, engine.handleTelnetSubneg)
 
 	return engine
 }
@@ -84,8 +99,17 @@ func (engine *LuaEngine) loadCoreModules() error {
 		{"events", "core/events.lua"},     // Most fundamental, others depend on it
 		{"alias", "core/alias.lua"},       // Input and commands depend on this
 		{"input", "core/input.lua"},       // Core input handling
+		{"prompt", "core/prompt.lua"},     // Prompt tracking, before triggers use it
 		{"trigger", "core/trigger.lua"},   // Output processing
 		{"timer", "core/timer.lua"},       // Timer system
+		{"gmcp", "core/gmcp.lua"},         // GMCP package tracking
+		{"msdp", "core/msdp.lua"},         // MSDP variable tracking
+		{"mssp", "core/mssp.lua"},         // MSSP server status
+		{"mxp", "core/mxp.lua"},           // MXP links
+		{"msp", "core/msp.lua"},           // MSP sound triggers
+		{"window", "core/window.lua"},     // Window size reporting
+		{"charset", "core/charset.lua"},   // Text encoding
+		{"telnet", "core/telnet.lua"},     // Raw telnet options
 		{"commands", "core/commands.lua"}, // Default commands, depends on alias
 		{"init", "core/init.lua"},         // Final initialization
 	}
@@ -142,20 +166,256 @@ func (engine *LuaEngine) initializeEventSystem() error {
 
 // Raw event handlers that bridge between Go events and Lua events
 func (engine *LuaEngine) handleRawInput(event events.Event) {
-	engine.emitLuaEvent("input", event.Data.(string))
+	engine.emitLuaEvent("input", lua.LString(event.Data.(string)))
 }
 
 func (engine *LuaEngine) handleRawOutput(event events.Event) {
-	engine.emitLuaEvent("output", event.Data.(string))
+	engine.emitLuaEvent("output", lua.LString(event.Data.(string)))
+}
+
+// handleConnectProgress tells scripts how a connection attempt is going
+func (engine *LuaEngine) handleConnectProgress(event events.Event) {
+	progress, ok := event.Data.(struct {
+		Stage   string
+		Address string
+		Reason  string
+	})
+	if !ok {
+		return
+	}
+
+	data := engine.L.NewTable()
+	data.RawSetString("stage", lua.LString(progress.Stage))
+	data.RawSetString("address", lua.LString(progress.Address))
+	if progress.Reason != "" {
+		data.RawSetString("reason", lua.LString(progress.Reason))
+	}
+	engine.emitLuaEvent("connect_progress", data)
+}
+
+// handleIdle tells scripts how many seconds have passed without input or
+// output
+func (engine *LuaEngine) handleIdle(event events.Event) {
+	idle, ok := event.Data.(time.Duration)
+	if !ok {
+		return
+	}
+	engine.emitLuaEvent("idle", lua.LNumber(idle.Seconds()))
+}
+
+func (engine *LuaEngine) handleConnected(event events.Event) {
+	status, ok := event.Data.(struct {
+		Host       string
+		Port       int
+		TLSVersion string
+		TLSCipher  string
+	})
+	if !ok {
+		return
+	}
+
+	data := engine.L.NewTable()
+	data.RawSetString("host", lua.LString(status.Host))
+	data.RawSetString("port", lua.LNumber(status.Port))
+	if status.TLSVersion != "" {
+		data.RawSetString("tls_version", lua.LString(status.TLSVersion))
+		data.RawSetString("tls_cipher", lua.LString(status.TLSCipher))
+	}
+	engine.emitLuaEvent("connect", data)
+}
+
+func (engine *LuaEngine) handlePrompt(event events.Event) {
+	engine.emitLuaEvent("prompt", lua.LString(event.Data.(string)))
+}
+
+func (engine *LuaEngine) handleGMCP(event events.Event) {
+	msg, ok := event.Data.(struct {
+		Package string
+		Data    []byte
+	})
+	if !ok {
+		return
+	}
+
+	L := engine.L
+	value, err := jsonToLua(L, msg.Data)
+	if err != nil {
+		// Keep malformed payloads available to scripts as plain text
+		value = lua.LString(msg.Data)
+	}
+
+	data := L.NewTable()
+	data.RawSetString("package", lua.LString(msg.Package))
+	data.RawSetString("data", value)
+	engine.emitLuaEvent("gmcp", data)
+}
+
+func (engine *LuaEngine) handleMSDP(event events.Event) {
+	msg, ok := event.Data.(struct {
+		Variable string
+		Value    interface{}
+	})
+	if !ok {
+		return
+	}
+
+	L := engine.L
+	data := L.NewTable()
+	data.RawSetString("variable", lua.LString(msg.Variable))
+	data.RawSetString("value", toLuaValue(L, msg.Value))
+	engine.emitLuaEvent("msdp", data)
+}
+
+func (engine *LuaEngine) handleMSSP(event events.Event) {
+	vars, ok := event.Data.(map[string][]string)
+	if !ok {
+		return
+	}
+
+	L := engine.L
+	data := L.CreateTable(0, len(vars))
+	for name, values := range vars {
+		list := L.CreateTable(len(values), 0)
+		for _, value := range values {
+			list.Append(lua.LString(value))
+		}
+		data.RawSetString(name, list)
+	}
+	engine.emitLuaEvent("mssp", data)
+}
+
+func (engine *LuaEngine) handleMXPLink(event events.Event) {
+	link, ok := event.Data.(struct {
+		Start    int
+		End      int
+		Text     string
+		Commands []string
+		Hints    []string
+		URL      string
+		Prompt   bool
+	})
+	if !ok {
+		return
+	}
+
+	L := engine.L
+	data := L.NewTable()
+	data.RawSetString("text", lua.LString(link.Text))
+	// Where the text is in the line of output, for string.sub
+	data.RawSetString("first", lua.LNumber(link.Start+1))
+	data.RawSetString("last", lua.LNumber(link.End))
+	data.RawSetString("commands", toLuaValue(L, toInterfaces(link.Commands)))
+	data.RawSetString("hints", toLuaValue(L, toInterfaces(link.Hints)))
+	data.RawSetString("prompt", lua.LBool(link.Prompt))
+	if link.URL != "" {
+		data.RawSetString("url", lua.LString(link.URL))
+	}
+	engine.emitLuaEvent("mxp_link", data)
+}
+
+// handleMSP passes a sound trigger to scripts as a "sound" or "music" event
+func (engine *LuaEngine) handleMSP(event events.Event) {
+	trigger, ok := event.Data.(struct {
+		Music    bool
+		File     string
+		Stop     bool
+		Volume   int
+		Loops    int
+		Priority int
+		Continue bool
+		Type     string
+		URL      string
+	})
+	if !ok {
+		return
+	}
+
+	data := engine.L.NewTable()
+	data.RawSetString("file", lua.LString(trigger.File))
+	data.RawSetString("stop", lua.LBool(trigger.Stop))
+	data.RawSetString("volume", lua.LNumber(trigger.Volume))
+	data.RawSetString("loops", lua.LNumber(trigger.Loops))
+	if trigger.Type != "" {
+		data.RawSetString("type", lua.LString(trigger.Type))
+	}
+	if trigger.URL != "" {
+		data.RawSetString("url", lua.LString(trigger.URL))
+	}
+	if trigger.Music {
+		data.RawSetString("continue", lua.LBool(trigger.Continue))
+		engine.emitLuaEvent("music", data)
+	} else {
+		data.RawSetString("priority", lua.LNumber(trigger.Priority))
+		engine.emitLuaEvent("sound", data)
+	}
+}
+
+func (engine *LuaEngine) handleWindowSize(event events.Event) {
+	size, ok := event.Data.(struct {
+		Cols int
+		Rows int
+	})
+	if !ok || engine.cachedEmitFn == nil {
+		return
+	}
+
+	data := engine.L.NewTable()
+	data.RawSetString("cols", lua.LNumber(size.Cols))
+	data.RawSetString("rows", lua.LNumber(size.Rows))
+	engine.emitLuaEvent("window_size", data)
+}
+
+func (engine *LuaEngine) handleEncoding(event events.Event) {
+	engine.emitLuaEvent("encoding", lua.LString(event.Data.(string)))
+}
+
+// handleEcho tells scripts when the server takes over echoing input, which
+// means typed text is hidden, and when it hands it back
+func (engine *LuaEngine) handleEcho(event events.Event) {
+	if event.Data.(bool) {
+		engine.emitLuaEvent("echo_off", lua.LNil)
+	} else {
+		engine.emitLuaEvent("echo_on", lua.LNil)
+	}
+}
+
+func (engine *LuaEngine) handleTelnetNegotiation(event events.Event) {
+	msg, ok := event.Data.(struct {
+		Command string
+		Option  byte
+	})
+	if !ok {
+		return
+	}
+
+	data := engine.L.NewTable()
+	data.RawSetString("command", lua.LString(msg.Command))
+	data.RawSetString("option", lua.LNumber(msg.Option))
+	engine.emitLuaEvent("telnet_negotiation", data)
+}
+
+func (engine *LuaEngine) handleTelnetSubneg(event events.Event) {
+	msg, ok := event.Data.(struct {
+		Option byte
+		Data   []byte
+	})
+	if !ok {
+		return
+	}
+
+	data := engine.L.NewTable()
+	data.RawSetString("option", lua.LNumber(msg.Option))
+	data.RawSetString("data", lua.LString(msg.Data))
+	engine.emitLuaEvent("telnet_subneg", data)
 }
 
 // emitLuaEvent sends an event to the Lua event system
-func (engine *LuaEngine) emitLuaEvent(eventName string, eventData string) {
+func (engine *LuaEngine) emitLuaEvent(eventName string, eventData lua.LValue) {
 	L := engine.L
 
 	L.Push(engine.cachedEmitFn)
 	L.Push(lua.LString(eventName))
-	L.Push(lua.LString(eventData))
+	L.Push(eventData)
 
 	if err := L.PCall(2, 0, nil); err != nil {
 		fmt.Printf("[ERROR] Failed to emit Lua event %s: %v\n", eventName, err)
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/mmcdole/runes/pkg/events"
 )
@@ -18,6 +19,7 @@ type testCase struct {
 	SetupLua         any            `json:"setup_lua"`
 	Input            string         `json:"input,omitempty"`
 	Output           string         `json:"output,omitempty"`
+	Prompt           string         `json:"prompt,omitempty"`
 	ExpectedCommands []string       `json:"expected_commands,omitempty"`
 	ExpectedEvents   []events.Event `json:"expected_events,omitempty"`
 }
@@ -128,6 +130,12 @@ func executeTest(t *testing.T, feature string, tt testCase) {
 				Data: tt.Output,
 			})
 		}
+		if tt.Prompt != "" {
+			engine.eventSystem.Emit(events.Event{
+				Type: events.EventPrompt,
+				Data: tt.Prompt,
+			})
+		}
 
 		if tt.ExpectedEvents != nil {
 			assertEvents(t, collector, tt.ExpectedEvents)
@@ -258,3 +266,536 @@ func TestFeatures(t *testing.T) {
 		}
 	}
 }
+
+func TestGMCP(t *testing.T) {
+	engine, collector, cleanup := setupTest(t)
+	defer cleanup()
+
+	var sent []string
+	engine.eventSystem.Subscribe(events.EventGMCPSend, func(e events.Event) {
+		msg := e.Data.(struct {
+			Package string
+			Data    []byte
+		})
+		sent = append(sent, msg.Package+" "+string(msg.Data))
+	})
+
+	executeSetupLua(t, engine, []interface{}{
+		"gmcp.on('Char.Vitals', function(data) runes.send('hp=' .. data.hp .. ',' .. data.flags[2]) end)",
+		"gmcp.send('Char.Skills.Get', {group = 'combat'})",
+		"gmcp.send('Core.Ping')",
+	})
+
+	engine.eventSystem.Emit(events.Event{
+		Type: events.EventGMCP,
+		Data: struct {
+			Package string
+			Data    []byte
+		}{"Char.Vitals", []byte(`{"hp": 42, "flags": ["a", "b"]}`)},
+	})
+
+	assertCommands(t, collector, []string{"hp=42,b"})
+
+	if err := engine.L.DoString("assert(gmcp.Char.Vitals.hp == 42)"); err != nil {
+		t.Errorf("expected latest value in gmcp table: %v", err)
+	}
+
+	want := []string{`Char.Skills.Get {"group":"combat"}`, "Core.Ping "}
+	if len(sent) != len(want) {
+		t.Fatalf("expected %d GMCP messages sent, got %v", len(want), sent)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf("message %d: expected %q, got %q", i, want[i], sent[i])
+		}
+	}
+}
+
+func TestMSDP(t *testing.T) {
+	engine, collector, cleanup := setupTest(t)
+	defer cleanup()
+
+	var sent [][]string
+	engine.eventSystem.Subscribe(events.EventMSDPSend, func(e events.Event) {
+		msg := e.Data.(struct {
+			Variable string
+			Values   []string
+		})
+		sent = append(sent, append([]string{msg.Variable}, msg.Values...))
+	})
+
+	executeSetupLua(t, engine, []interface{}{
+		"msdp.on('ROOM', function(room) runes.send('room=' .. room.VNUM .. ',' .. room.TAGS[1]) end)",
+		"msdp.report('HEALTH', 'MANA')",
+		"msdp.list('COMMANDS')",
+	})
+
+	engine.eventSystem.Emit(events.Event{
+		Type: events.EventMSDP,
+		Data: struct {
+			Variable string
+			Value    interface{}
+		}{"ROOM", map[string]interface{}{"VNUM": "6008", "TAGS": []interface{}{"dark"}}},
+	})
+	engine.eventSystem.Emit(events.Event{
+		Type: events.EventMSDP,
+		Data: struct {
+			Variable string
+			Value    interface{}
+		}{"HEALTH", "90"},
+	})
+
+	assertCommands(t, collector, []string{"room=6008,dark"})
+
+	if err := engine.L.DoString("assert(msdp.HEALTH == '90' and msdp.ROOM.VNUM == '6008')"); err != nil {
+		t.Errorf("expected current values in msdp table: %v", err)
+	}
+
+	want := [][]string{{"REPORT", "HEALTH", "MANA"}, {"LIST", "COMMANDS"}}
+	if fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("expected requests %v, got %v", want, sent)
+	}
+}
+
+func TestMSSP(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	engine.eventSystem.Emit(events.Event{
+		Type: events.EventMSSP,
+		Data: map[string][]string{"NAME": {"Test MUD"}, "PORT": {"4000", "5000"}},
+	})
+
+	if err := engine.L.DoString("assert(mssp.get('NAME') == 'Test MUD' and mssp.variables.PORT[2] == '5000')"); err != nil {
+		t.Errorf("expected server status in mssp table: %v", err)
+	}
+}
+
+func TestMXPLinks(t *testing.T) {
+	engine, collector, cleanup := setupTest(t)
+	defer cleanup()
+
+	emitLink := func(start int, text string, commands ...string) {
+		engine.eventSystem.Emit(events.Event{
+			Type: events.EventMXPLink,
+			Data: struct {
+				Start    int
+				End      int
+				Text     string
+				Commands []string
+				Hints    []string
+				URL      string
+				Prompt   bool
+			}{Start: start, End: start + len(text), Text: text, Commands: commands},
+		})
+	}
+	emitLink(7, "north", "go north")
+	emitLink(10, "a sword", "get sword", "look sword")
+
+	engine.eventSystem.Emit(events.Event{Type: events.EventRawInput, Data: "/link 1"})
+	engine.eventSystem.Emit(events.Event{Type: events.EventRawInput, Data: "/link 2 2"})
+	engine.eventSystem.Emit(events.Event{Type: events.EventRawInput, Data: "/links"})
+
+	assertCommands(t, collector, []string{"go north", "look sword"})
+	if err := engine.L.DoString(`assert(("Exits: north"):sub(mxp.get(1).first, mxp.get(1).last) == "north")
+		assert(mxp.get(2).first == 11 and mxp.get(2).last == 17)`); err != nil {
+		t.Errorf("unexpected link positions: %v", err)
+	}
+}
+
+func TestWindowSize(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	var requested []string
+	engine.eventSystem.Subscribe(events.EventSetWindowSize, func(e events.Event) {
+		size := e.Data.(struct {
+			Cols int
+			Rows int
+		})
+		requested = append(requested, fmt.Sprintf("%dx%d", size.Cols, size.Rows))
+	})
+
+	engine.eventSystem.Emit(events.Event{
+		Type: events.EventWindowSize,
+		Data: struct {
+			Cols int
+			Rows int
+		}{132, 50},
+	})
+	if err := engine.L.DoString("local c, r = window.size(); assert(c == 132 and r == 50)"); err != nil {
+		t.Errorf("expected window.size to report 132x50: %v", err)
+	}
+
+	executeSetupLua(t, engine, []interface{}{"window.set_size(200, 60)", "window.reset_size()"})
+	if want := "[200x60 0x0]"; fmt.Sprint(requested) != want {
+		t.Errorf("expected size requests %s, got %v", want, requested)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	var requested []string
+	engine.eventSystem.Subscribe(events.EventSetEncoding, func(e events.Event) {
+		requested = append(requested, e.Data.(string))
+	})
+
+	engine.eventSystem.Emit(events.Event{Type: events.EventRawInput, Data: "/encoding cp437"})
+	if want := "[cp437]"; fmt.Sprint(requested) != want {
+		t.Errorf("expected encoding requests %s, got %v", want, requested)
+	}
+
+	engine.eventSystem.Emit(events.Event{Type: events.EventEncoding, Data: "CP437"})
+	if err := engine.L.DoString(`assert(charset.current() == "CP437")`); err != nil {
+		t.Errorf("expected charset.current to report CP437: %v", err)
+	}
+}
+
+func TestConnectOptions(t *testing.T) {
+	engine, collector, cleanup := setupTest(t)
+	defer cleanup()
+
+	inputs := []string{
+		"/connect example.com 4000",
+		"/connect --tls example.com 4443",
+		"/connect tls://example.com:4443",
+		"/connect --ca-file ca.pem --fingerprint aa:bb --fingerprint cc example.com 4443",
+		"/connect --insecure tls://example.com 4443",
+		"/connect --proxy socks5://u:p@127.0.0.1:1080 example.com 4000",
+		"/connect --bogus example.com 4000",
+		"/connect tls://example.com",
+		"/connect --insecure wss://example.com/mud",
+		"/connect ws://example.com 4000",
+		"/connect --identity mud_key ssh://player@example.com",
+		`/connect --plain exec:"./mud --port 0"`,
+		"/connect --insecure example.com 4000",
+		"/connect --insecure ssh://player@example.com",
+		"/connect --insecure-host-key ssh://player@example.com",
+		"/connect --insecure-host-key example.com 4000",
+	}
+	for _, input := range inputs {
+		engine.eventSystem.Emit(events.Event{Type: events.EventRawInput, Data: input})
+	}
+
+	want := []string{
+		"{example.com 4000 false  [] false    false false}",
+		"{example.com 4443 true  [] false    false false}",
+		"{example.com 4443 true  [] false    false false}",
+		"{example.com 4443 true ca.pem [aa:bb cc] false    false false}",
+		"{example.com 4443 true  [] true    false false}",
+		"{example.com 4000 false  [] false socks5://u:p@127.0.0.1:1080   false false}",
+		"{ 0 true  [] true  wss://example.com/mud  false false}",
+		"{ 0 false  [] false  ssh://player@example.com mud_key false false}",
+		`{ 0 false  [] false  exec:"./mud --port 0"  false true}`,
+		"{ 0 false  [] false  ssh://player@example.com  true false}",
+	}
+	collector.Lock()
+	defer collector.Unlock()
+	var got []string
+	for _, e := range collector.events {
+		if e.Type == events.EventConnect {
+			got = append(got, fmt.Sprint(e.Data))
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected connect requests %q, got %q", want, got)
+	}
+}
+
+func TestTelnetHandlers(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	var sent, options []string
+	engine.eventSystem.Subscribe(events.EventTelnetSend, func(e events.Event) {
+		msg := e.Data.(struct {
+			Option byte
+			Data   []byte
+		})
+		sent = append(sent, fmt.Sprintf("%d:%q", msg.Option, msg.Data))
+	})
+	engine.eventSystem.Subscribe(events.EventTelnetOption, func(e events.Event) {
+		msg := e.Data.(struct {
+			Option byte
+			Local  bool
+			Enable bool
+		})
+		options = append(options, fmt.Sprintf("%d:%v:%v", msg.Option, msg.Local, msg.Enable))
+	})
+
+	executeSetupLua(t, engine, []interface{}{
+		`seen = {}`,
+		`telnet.on(200, function(data) table.insert(seen, "sb:" .. data); telnet.send(200, "ack") end)`,
+		`telnet.on_negotiation(200, function(cmd) table.insert(seen, cmd) end)`,
+		`telnet.enable(200)`,
+		`telnet.disable(201, "local")`,
+	})
+
+	engine.eventSystem.Emit(events.Event{
+		Type: events.EventTelnetNegotiation,
+		Data: struct {
+			Command string
+			Option  byte
+		}{"WILL", 200},
+	})
+	engine.eventSystem.Emit(events.Event{
+		Type: events.EventTelnetSubneg,
+		Data: struct {
+			Option byte
+			Data   []byte
+		}{200, []byte("hello\xff")},
+	})
+	engine.eventSystem.Emit(events.Event{
+		Type: events.EventTelnetSubneg,
+		Data: struct {
+			Option byte
+			Data   []byte
+		}{201, []byte("other")},
+	})
+
+	if err := engine.L.DoString(`assert(table.concat(seen, ",") == "WILL,sb:hello\255")`); err != nil {
+		t.Errorf("expected handlers to see WILL and the payload: %v", err)
+	}
+	if want := `[200:"ack"]`; fmt.Sprint(sent) != want {
+		t.Errorf("expected sent %s, got %v", want, sent)
+	}
+	if want := "[200:false:true 201:true:false]"; fmt.Sprint(options) != want {
+		t.Errorf("expected options %s, got %v", want, options)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	executeSetupLua(t, engine, []interface{}{
+		`seen = {}`,
+		`events.add("echo_off", function() table.insert(seen, "off") end)`,
+		`events.add("echo_on", function() table.insert(seen, "on") end)`,
+	})
+
+	engine.eventSystem.Emit(events.Event{Type: events.EventEcho, Data: true})
+	if err := engine.L.DoString(`assert(runes.input_masked())`); err != nil {
+		t.Errorf("expected input to be masked while the server echoes: %v", err)
+	}
+	engine.eventSystem.Emit(events.Event{Type: events.EventEcho, Data: false})
+	if err := engine.L.DoString(`assert(not runes.input_masked() and table.concat(seen, ",") == "off,on")`); err != nil {
+		t.Errorf("expected echo_off then echo_on: %v", err)
+	}
+}
+
+func TestSetEnviron(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	var set []string
+	engine.eventSystem.Subscribe(events.EventSetEnviron, func(e events.Event) {
+		msg := e.Data.(struct {
+			Name  string
+			Value string
+		})
+		set = append(set, msg.Name+"="+msg.Value)
+	})
+
+	executeSetupLua(t, engine, []interface{}{
+		`telnet.set_environ("SCREEN_READER", 1)`,
+	})
+	if want := "[SCREEN_READER=1]"; fmt.Sprint(set) != want {
+		t.Errorf("expected %s, got %v", want, set)
+	}
+}
+
+func TestConnectProgress(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	var timeouts []time.Duration
+	for _, eventType := range []events.EventType{events.EventSetConnectTimeout, events.EventSetIdleTimeout} {
+		engine.eventSystem.Subscribe(eventType, func(e events.Event) {
+			timeouts = append(timeouts, e.Data.(time.Duration))
+		})
+	}
+
+	executeSetupLua(t, engine, []interface{}{
+		`seen = {}`,
+		`events.add("connect_progress", function(data)
+			table.insert(seen, data.stage .. " " .. data.address .. (data.reason and (" " .. data.reason) or ""))
+		end)`,
+		`runes.set_connect_timeout(2.5)`,
+		`runes.set_idle_timeout(600)`,
+	})
+	if want := "[2.5s 10m0s]"; fmt.Sprint(timeouts) != want {
+		t.Errorf("expected timeouts %s, got %v", want, timeouts)
+	}
+
+	for _, progress := range []struct {
+		Stage   string
+		Address string
+		Reason  string
+	}{
+		{"resolving", "example.com", ""},
+		{"connecting", "[2001:db8::1]:4000", ""},
+		{"failed", "example.com:4000", "cancelled"},
+	} {
+		engine.eventSystem.Emit(events.Event{Type: events.EventConnectProgress, Data: progress})
+	}
+	err := engine.L.DoString(`assert(table.concat(seen, ",") ==
+		"resolving example.com,connecting [2001:db8::1]:4000,failed example.com:4000 cancelled", table.concat(seen, ","))`)
+	if err != nil {
+		t.Errorf("unexpected progress events: %v", err)
+	}
+}
+
+func TestCompressionCommand(t *testing.T) {
+	engine, collector, cleanup := setupTest(t)
+	defer cleanup()
+
+	connected := false
+	engine.eventSystem.Subscribe(events.EventCompressionStats, func(e events.Event) {
+		if connected {
+			*e.Data.(*events.CompressionStats) = events.CompressionStats{
+				Connected:         true,
+				Active:            true,
+				Streams:           1,
+				CompressedBytes:   100,
+				DecompressedBytes: 450,
+				OutboundBytes:     20,
+			}
+		}
+	})
+	var compress []bool
+	engine.eventSystem.Subscribe(events.EventSetCompressOutput, func(e events.Event) {
+		compress = append(compress, e.Data.(bool))
+	})
+	for _, input := range []string{"/mccp", "/mccp off", "/mccp maybe", "/mccp on"} {
+		engine.eventSystem.Emit(events.Event{Type: events.EventRawInput, Data: input})
+	}
+	connected = true
+	engine.eventSystem.Emit(events.Event{Type: events.EventRawInput, Data: "/mccp"})
+	executeSetupLua(t, engine, []interface{}{
+		`runes.set_compress_output(false)`,
+		`stats = runes.compression_stats()`,
+	})
+	if want := "[false true false]"; fmt.Sprint(compress) != want {
+		t.Errorf("expected %s, got %v", want, compress)
+	}
+	if err := engine.L.DoString(`assert(stats.active and stats.streams == 1 and stats.compressed == 100 and
+		stats.decompressed == 450 and not stats.outbound_active and stats.outbound == 20 and
+		stats.outbound_compressed == 0)`); err != nil {
+		t.Errorf("unexpected compression stats table: %v", err)
+	}
+
+	collector.Lock()
+	defer collector.Unlock()
+	var output []string
+	for _, e := range collector.events {
+		if e.Type == events.EventOutput {
+			output = append(output, fmt.Sprint(e.Data))
+		}
+	}
+	for _, want := range []string{
+		"Not connected to a telnet server",
+		"MCCP2 (from server): on, 1 streams, 100 bytes received inflated to 450 (4.5x)",
+		"MCCP3 (to server): off, 20 bytes sent deflated to 0",
+	} {
+		if !strings.Contains(strings.Join(output, "\n"), want) {
+			t.Errorf("expected output %q, got %q", want, output)
+		}
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	var got []string
+	for _, eventType := range []events.EventType{events.EventSetKeepalive, events.EventSetProbe, events.EventSetIdleEvent} {
+		engine.eventSystem.Subscribe(eventType, func(e events.Event) {
+			got = append(got, fmt.Sprintf("%s %v", e.Type, e.Data))
+		})
+	}
+
+	executeSetupLua(t, engine, []interface{}{
+		`idle = {}`,
+		`events.add("idle", function(seconds) table.insert(idle, seconds) end)`,
+		`runes.set_keepalive(30)`,
+		`runes.set_probe(60)`,
+		`runes.set_probe(10, "ayt")`,
+		`runes.set_idle_event(300)`,
+	})
+	want := "[set_keepalive 30s set_probe {1m0s nop} set_probe {10s ayt} set_idle_event 5m0s]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("expected %s, got %v", want, got)
+	}
+
+	engine.eventSystem.Emit(events.Event{Type: events.EventIdle, Data: 90 * time.Second})
+	if err := engine.L.DoString(`assert(#idle == 1 and idle[1] == 90, tostring(idle[1]))`); err != nil {
+		t.Errorf("expected an idle event of 90 seconds: %v", err)
+	}
+}
+
+func TestMSP(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	executeSetupLua(t, engine, []interface{}{
+		`sounds = {}`,
+		`events.add("sound", function(s) table.insert(sounds, s.file .. " " .. s.volume .. " " .. s.priority .. " " .. tostring(msp.url(s))) end)`,
+	})
+
+	emit := func(music bool, file string, stop bool, url string) {
+		engine.eventSystem.Emit(events.Event{
+			Type: events.EventMSP,
+			Data: struct {
+				Music    bool
+				File     string
+				Stop     bool
+				Volume   int
+				Loops    int
+				Priority int
+				Continue bool
+				Type     string
+				URL      string
+			}{music, file, stop, 80, 1, 50, true, "", url},
+		})
+	}
+	emit(false, "hit.wav", false, "")
+	emit(false, "Off", true, "http://example.com/sounds")
+	emit(false, "miss.wav", false, "")
+	emit(true, "town.mid", false, "")
+
+	if err := engine.L.DoString(`assert(table.concat(sounds, ",") ==
+		"hit.wav 80 50 nil,Off 80 50 nil,miss.wav 80 50 http://example.com/sounds/miss.wav",
+		table.concat(sounds, ","))`); err != nil {
+		t.Errorf("unexpected sounds: %v", err)
+	}
+	if err := engine.L.DoString(`assert(msp.music.file == "town.mid" and msp.music.continue)`); err != nil {
+		t.Errorf("expected town.mid to be the current music: %v", err)
+	}
+	emit(true, "Off", true, "")
+	if err := engine.L.DoString(`assert(msp.music == nil)`); err != nil {
+		t.Errorf("expected music to stop: %v", err)
+	}
+}
+
+func TestRecordCommand(t *testing.T) {
+	engine, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	var got []string
+	engine.eventSystem.Subscribe(events.EventRecordStart, func(e events.Event) {
+		got = append(got, "start "+e.Data.(string))
+	})
+	engine.eventSystem.Subscribe(events.EventRecordStop, func(e events.Event) {
+		got = append(got, "stop")
+	})
+
+	for _, input := range []string{"/record start logs/my session.rec", "/record", "/record start", "/record stop"} {
+		engine.eventSystem.Emit(events.Event{Type: events.EventRawInput, Data: input})
+	}
+	if want := "[start logs/my session.rec stop]"; fmt.Sprint(got) != want {
+		t.Errorf("expected %s, got %v", want, got)
+	}
+}
@@ -0,0 +1,375 @@
+package mudtest
+
+import (
+	"bufio"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Kind is the kind of a message from the client
+type Kind int
+
+const (
+	Line           Kind = iota // A line of text
+	Command                    // A telnet command such as DO or NOP
+	Subnegotiation             // A subnegotiation, IAC SB ... IAC SE
+)
+
+// Message is something the client sent
+type Message struct {
+	Kind    Kind
+	Text    string // Line: the text without its line ending
+	Command byte   // Command: the command, e.g. DO
+	Option  byte   // Command and Subnegotiation: the option, if any
+	Data    []byte // Subnegotiation: the payload after the option, unescaped
+}
+
+func (m Message) String() string {
+	switch m.Kind {
+	case Line:
+		return fmt.Sprintf("line %q", m.Text)
+	case Command:
+		return fmt.Sprintf("command %d %d", m.Command, m.Option)
+	default:
+		return fmt.Sprintf("subnegotiation %d %q", m.Option, m.Data)
+	}
+}
+
+// Conn is the server's side of a client connection. Its methods fail the
+// test on errors, so call them from the goroutine running the test.
+type Conn struct {
+	t    testing.TB
+	conn net.Conn
+	msgs chan Message
+	done chan struct{} // Closed by Close
+
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	w  io.Writer    // The connection, or zw while compressing
+	zw *zlib.Writer // MCCP2 compressor, nil when not compressing
+}
+
+func newConn(t testing.TB, conn net.Conn) *Conn {
+	c := &Conn{t: t, conn: conn, w: conn, msgs: make(chan Message, 256), done: make(chan struct{})}
+	go c.read()
+	return c
+}
+
+// Close hangs up on the client
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.conn.Close()
+}
+
+// SendRaw sends bytes to the client as they are, compressed if MCCP2 is on
+func (c *Conn) SendRaw(data []byte) {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.w.Write(data)
+	if err == nil && c.zw != nil {
+		err = c.zw.Flush()
+	}
+	if err != nil {
+		c.t.Fatal("mudtest: send failed:", err)
+	}
+}
+
+// Send sends text, escaping any IAC bytes
+func (c *Conn) Send(text string) {
+	c.t.Helper()
+	c.SendRaw(escape(nil, []byte(text)))
+}
+
+// SendLine sends text followed by CR LF
+func (c *Conn) SendLine(text string) {
+	c.t.Helper()
+	c.Send(text + "\r\n")
+}
+
+// Prompt sends text followed by IAC GA, marking it as a prompt
+func (c *Conn) Prompt(text string) {
+	c.t.Helper()
+	c.SendRaw(append(escape(nil, []byte(text)), IAC, GA))
+}
+
+// Will sends IAC WILL opt
+func (c *Conn) Will(opt byte) {
+	c.t.Helper()
+	c.SendRaw([]byte{IAC, WILL, opt})
+}
+
+// Wont sends IAC WONT opt
+func (c *Conn) Wont(opt byte) {
+	c.t.Helper()
+	c.SendRaw([]byte{IAC, WONT, opt})
+}
+
+// Do sends IAC DO opt
+func (c *Conn) Do(opt byte) {
+	c.t.Helper()
+	c.SendRaw([]byte{IAC, DO, opt})
+}
+
+// Dont sends IAC DONT opt
+func (c *Conn) Dont(opt byte) {
+	c.t.Helper()
+	c.SendRaw([]byte{IAC, DONT, opt})
+}
+
+// Subnegotiate sends IAC SB opt data IAC SE, escaping IAC bytes in data
+func (c *Conn) Subnegotiate(opt byte, data []byte) {
+	c.t.Helper()
+	msg := escape([]byte{IAC, SB, opt}, data)
+	c.SendRaw(append(msg, IAC, SE))
+}
+
+// SendGMCP sends a GMCP message. An empty data sends the package alone.
+func (c *Conn) SendGMCP(pkg, data string) {
+	c.t.Helper()
+	if data != "" {
+		pkg += " " + data
+	}
+	c.Subnegotiate(OptGMCP, []byte(pkg))
+}
+
+// StartCompression starts an MCCP2 stream: everything sent afterwards is
+// compressed until EndCompression. The client should have agreed with
+// DO MCCP2 first.
+func (c *Conn) StartCompression() {
+	c.t.Helper()
+	c.SendRaw([]byte{IAC, SB, OptMCCP2, IAC, SE})
+	c.mu.Lock()
+	c.zw = zlib.NewWriter(c.conn)
+	c.w = c.zw
+	c.mu.Unlock()
+}
+
+// EndCompression ends the MCCP2 stream, sending later data uncompressed
+func (c *Conn) EndCompression() {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.zw == nil {
+		return
+	}
+	if err := c.zw.Close(); err != nil {
+		c.t.Fatal("mudtest: ending compression failed:", err)
+	}
+	c.zw = nil
+	c.w = c.conn
+}
+
+// Next returns the next message from the client, failing the test if none
+// arrives within DefaultTimeout or the client hangs up
+func (c *Conn) Next() Message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("mudtest: client closed the connection")
+		}
+		return msg
+	case <-time.After(DefaultTimeout):
+		c.t.Fatal("mudtest: nothing received from the client")
+	}
+	return Message{}
+}
+
+// Expect skips messages until one matches, failing the test if none does
+// within DefaultTimeout. want describes the message for the failure.
+func (c *Conn) Expect(want string, match func(Message) bool) Message {
+	c.t.Helper()
+	var skipped []string
+	deadline := time.After(DefaultTimeout)
+	for {
+		select {
+		case msg, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("mudtest: client closed the connection while waiting for %s, after %v", want, skipped)
+			}
+			if match(msg) {
+				return msg
+			}
+			skipped = append(skipped, msg.String())
+		case <-deadline:
+			c.t.Fatalf("mudtest: timed out waiting for %s, got %v", want, skipped)
+			return Message{}
+		}
+	}
+}
+
+// ExpectLine waits for the client to send a line of text
+func (c *Conn) ExpectLine(text string) {
+	c.t.Helper()
+	c.Expect(fmt.Sprintf("line %q", text), func(m Message) bool {
+		return m.Kind == Line && m.Text == text
+	})
+}
+
+// ExpectCommand waits for the client to send a command such as DO opt
+func (c *Conn) ExpectCommand(cmd, opt byte) {
+	c.t.Helper()
+	c.Expect(fmt.Sprintf("command %d %d", cmd, opt), func(m Message) bool {
+		return m.Kind == Command && m.Command == cmd && m.Option == opt
+	})
+}
+
+// ExpectSubnegotiation waits for a subnegotiation of opt and returns its
+// payload
+func (c *Conn) ExpectSubnegotiation(opt byte) []byte {
+	c.t.Helper()
+	msg := c.Expect(fmt.Sprintf("subnegotiation %d", opt), func(m Message) bool {
+		return m.Kind == Subnegotiation && m.Option == opt
+	})
+	return msg.Data
+}
+
+// ExpectGMCP waits for a GMCP message for pkg and returns its data
+func (c *Conn) ExpectGMCP(pkg string) string {
+	c.t.Helper()
+	msg := c.Expect("GMCP "+pkg, func(m Message) bool {
+		name, _, _ := strings.Cut(string(m.Data), " ")
+		return m.Kind == Subnegotiation && m.Option == OptGMCP && strings.EqualFold(name, pkg)
+	})
+	_, data, _ := strings.Cut(string(msg.Data), " ")
+	return data
+}
+
+// ExpectClosed waits for the client to hang up, skipping anything it sends
+// first
+func (c *Conn) ExpectClosed() {
+	c.t.Helper()
+	deadline := time.After(DefaultTimeout)
+	for {
+		select {
+		case _, ok := <-c.msgs:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			c.t.Fatal("mudtest: client didn't close the connection")
+			return
+		}
+	}
+}
+
+// read parses what the client sends into messages until it hangs up. Once
+// the client starts MCCP3 the rest of the stream is decompressed.
+func (c *Conn) read() {
+	defer close(c.msgs)
+
+	r := bufio.NewReader(c.conn)
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if len(line) > 0 {
+				c.deliver(Message{Kind: Line, Text: string(line)})
+			}
+			return
+		}
+		if b != IAC {
+			if b == '\n' {
+				if !c.deliver(Message{Kind: Line, Text: strings.TrimSuffix(string(line), "\r")}) {
+					return
+				}
+				line = line[:0]
+			} else {
+				line = append(line, b)
+			}
+			continue
+		}
+
+		cmd, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch cmd {
+		case IAC:
+			line = append(line, IAC)
+		case WILL, WONT, DO, DONT:
+			opt, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			if !c.deliver(Message{Kind: Command, Command: cmd, Option: opt}) {
+				return
+			}
+		case SB:
+			data, err := readSubnegotiation(r)
+			if err != nil || len(data) == 0 {
+				return
+			}
+			if !c.deliver(Message{Kind: Subnegotiation, Option: data[0], Data: data[1:]}) {
+				return
+			}
+			if data[0] == OptMCCP3 {
+				zr, err := zlib.NewReader(r)
+				if err != nil {
+					return
+				}
+				r = bufio.NewReader(zr)
+			}
+		default:
+			if !c.deliver(Message{Kind: Command, Command: cmd}) {
+				return
+			}
+		}
+	}
+}
+
+// deliver queues a message for the test, returning false once the
+// connection has been closed
+func (c *Conn) deliver(msg Message) bool {
+	select {
+	case c.msgs <- msg:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// readSubnegotiation reads up to and including IAC SE, returning the
+// unescaped option and payload
+func readSubnegotiation(r *bufio.Reader) ([]byte, error) {
+	var data []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != IAC {
+			data = append(data, b)
+			continue
+		}
+		next, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if next == SE {
+			return data, nil
+		}
+		data = append(data, next)
+	}
+}
+
+// escape appends data to dst, doubling IAC bytes
+func escape(dst, data []byte) []byte {
+	for _, b := range data {
+		if b == IAC {
+			dst = append(dst, IAC)
+		}
+		dst = append(dst, b)
+	}
+	return dst
+}
@@ -0,0 +1,115 @@
+// Package mudtest provides a scriptable MUD server for tests. A test starts
+// a Server, points a client at it, then plays the server's side of the
+// session with a Conn: sending text, prompts, telnet negotiation, GMCP and
+// compressed streams, and checking what the client sends back.
+package mudtest
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Telnet commands
+const (
+	IAC  = 255
+	DONT = 254
+	DO   = 253
+	WONT = 252
+	WILL = 251
+	SB   = 250
+	GA   = 249
+	AYT  = 246
+	NOP  = 241
+	SE   = 240
+	EOR  = 239
+)
+
+// Telnet options
+const (
+	OptEcho         = 1
+	OptSuppressGA   = 3
+	OptTerminalType = 24
+	OptEOR          = 25
+	OptNAWS         = 31
+	OptNewEnviron   = 39
+	OptCharset      = 42
+	OptMSDP         = 69
+	OptMSSP         = 70
+	OptMCCP2        = 86
+	OptMCCP3        = 87
+	OptMSP          = 90
+	OptMXP          = 91
+	OptGMCP         = 201
+)
+
+// DefaultTimeout is how long Accept and the Expect methods wait
+const DefaultTimeout = 2 * time.Second
+
+// Server is a MUD listening on a loopback port. It is closed when the test
+// ends.
+type Server struct {
+	t     testing.TB
+	ln    net.Listener
+	conns chan net.Conn
+}
+
+// NewServer starts a server on a free loopback port
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("mudtest: failed to listen:", err)
+	}
+	s := &Server{t: t, ln: ln, conns: make(chan net.Conn, 8)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(s.conns)
+				return
+			}
+			s.conns <- conn
+		}
+	}()
+	return s
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Host returns the host the server listens on
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr())
+	return host
+}
+
+// Port returns the port the server listens on
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// Accept waits for the next client to connect, failing the test if none
+// does within DefaultTimeout
+func (s *Server) Accept() *Conn {
+	s.t.Helper()
+	select {
+	case conn, ok := <-s.conns:
+		if !ok {
+			s.t.Fatal("mudtest: server closed")
+		}
+		c := newConn(s.t, conn)
+		s.t.Cleanup(func() { c.Close() })
+		return c
+	case <-time.After(DefaultTimeout):
+		s.t.Fatal("mudtest: no client connected")
+	}
+	return nil
+}
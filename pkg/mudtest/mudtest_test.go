@@ -0,0 +1,171 @@
+package mudtest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fatalTB records the first Fatal call instead of failing the test, so the
+// tests can check how the helpers fail. Fatal stops the calling goroutine
+// like the real one does.
+type fatalTB struct {
+	testing.TB
+	msg string
+}
+
+func (f *fatalTB) Helper() {}
+
+func (f *fatalTB) Fatal(args ...any) {
+	f.msg = fmt.Sprint(args...)
+	runtime.Goexit()
+}
+
+func (f *fatalTB) Fatalf(format string, args ...any) {
+	f.msg = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+// goFatal runs fn on its own goroutine, returning a channel closed when
+// fn returns or fails
+func goFatal(fn func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	return done
+}
+
+// checkFatal checks that tb failed with a message containing want
+func checkFatal(t *testing.T, tb *fatalTB, want string) {
+	t.Helper()
+	if !strings.Contains(tb.msg, want) {
+		t.Errorf("expected a failure containing %q, got %q", want, tb.msg)
+	}
+}
+
+// dial connects a client to a new server, returning both ends
+func dial(t *testing.T, tb testing.TB) (*Conn, net.Conn) {
+	t.Helper()
+	server := NewServer(tb)
+	client, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal("dial failed:", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server.Accept(), client
+}
+
+func TestAccept(t *testing.T) {
+	conn, client := dial(t, t)
+
+	client.Write([]byte("look\r\n"))
+	client.Write([]byte{IAC, DO, OptGMCP, IAC, NOP})
+	client.Write([]byte{IAC, SB, OptGMCP})
+	client.Write([]byte("Core.Hello {\"client\":\"x\"}"))
+	client.Write([]byte{IAC, SE})
+
+	conn.ExpectLine("look")
+	conn.ExpectCommand(DO, OptGMCP)
+	if msg := conn.Next(); msg.Kind != Command || msg.Command != NOP {
+		t.Errorf("expected NOP, got %v", msg)
+	}
+	if data := conn.ExpectGMCP("core.hello"); data != `{"client":"x"}` {
+		t.Errorf("expected the Core.Hello data, got %q", data)
+	}
+
+	client.Close()
+	conn.ExpectClosed()
+}
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		fn   func(*Conn)
+	}{
+		{"Next", "nothing received", func(c *Conn) { c.Next() }},
+		{"ExpectLine", `timed out waiting for line "look", got [line "other"]`, func(c *Conn) { c.ExpectLine("look") }},
+		{"ExpectCommand", "timed out waiting for command 253 201", func(c *Conn) { c.ExpectCommand(DO, OptGMCP) }},
+		{"ExpectSubnegotiation", "timed out waiting for subnegotiation 24", func(c *Conn) { c.ExpectSubnegotiation(OptTerminalType) }},
+		{"ExpectGMCP", "timed out waiting for GMCP Core.Hello", func(c *Conn) { c.ExpectGMCP("Core.Hello") }},
+		{"ExpectClosed", "didn't close the connection", func(c *Conn) { c.ExpectClosed() }},
+	}
+
+	// The waits all run at once so the test takes one DefaultTimeout
+	tbs := make([]*fatalTB, len(tests))
+	dones := make([]<-chan struct{}, len(tests))
+	for i, test := range tests {
+		tbs[i] = &fatalTB{TB: t}
+		conn, client := dial(t, tbs[i])
+		if test.name == "ExpectLine" {
+			client.Write([]byte("other\r\n"))
+		}
+		dones[i] = goFatal(func() { test.fn(conn) })
+	}
+	acceptTB := &fatalTB{TB: t}
+	server := NewServer(acceptTB)
+	acceptDone := goFatal(func() { server.Accept() })
+
+	for i, test := range tests {
+		<-dones[i]
+		t.Run(test.name, func(t *testing.T) { checkFatal(t, tbs[i], test.want) })
+	}
+	<-acceptDone
+	t.Run("Accept", func(t *testing.T) { checkFatal(t, acceptTB, "no client connected") })
+}
+
+func TestExpectAfterClose(t *testing.T) {
+	tb := &fatalTB{TB: t}
+	conn, client := dial(t, tb)
+	client.Close()
+	<-goFatal(func() { conn.ExpectLine("look") })
+	checkFatal(t, tb, "client closed the connection while waiting for line")
+}
+
+func TestCompression(t *testing.T) {
+	conn, client := dial(t, t)
+
+	conn.StartCompression()
+	conn.SendLine("compressed")
+	conn.EndCompression()
+	conn.SendLine("plain")
+
+	r := bufio.NewReader(client)
+	start := make([]byte, 5)
+	if _, err := io.ReadFull(r, start); err != nil {
+		t.Fatal("read failed:", err)
+	}
+	if !bytes.Equal(start, []byte{IAC, SB, OptMCCP2, IAC, SE}) {
+		t.Fatalf("expected IAC SB MCCP2 IAC SE, got %v", start)
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		t.Fatal("zlib.NewReader failed:", err)
+	}
+	if data, err := io.ReadAll(zr); err != nil || string(data) != "compressed\r\n" {
+		t.Fatalf("expected the compressed line, got %q (%v)", data, err)
+	}
+	if line, err := r.ReadString('\n'); err != nil || line != "plain\r\n" {
+		t.Errorf("expected the plain line after the stream, got %q (%v)", line, err)
+	}
+}
+
+func TestClientCompression(t *testing.T) {
+	conn, client := dial(t, t)
+
+	client.Write([]byte{IAC, SB, OptMCCP3, IAC, SE})
+	zw := zlib.NewWriter(client)
+	zw.Write([]byte("north\r\n"))
+	zw.Flush()
+
+	conn.ExpectSubnegotiation(OptMCCP3)
+	conn.ExpectLine("north")
+}
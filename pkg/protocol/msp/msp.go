@@ -0,0 +1,114 @@
+// Package msp parses MUD Sound Protocol triggers, the !!SOUND(...) and
+// !!MUSIC(...) directives servers embed in their output.
+package msp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Trigger is a request from the server to play a sound or music
+type Trigger struct {
+	Music    bool   // A MUSIC trigger, otherwise a SOUND one
+	File     string // File to play, relative to the sound directory
+	Stop     bool   // The file was "Off": stop playing sounds or music
+	Volume   int    // 0 to 100
+	Loops    int    // Times to play, -1 to repeat until stopped
+	Priority int    // SOUND only: a higher priority sound interrupts a lower one
+	Continue bool   // MUSIC only: keep playing if the same file is already playing
+	Type     string // Category of sound, e.g. "combat", empty if not given
+	URL      string // Where to download the file, empty if not given
+}
+
+// Parse removes the MSP triggers from a line of output and returns the
+// remaining text and the triggers in order. A line that held nothing but
+// triggers comes back empty.
+func Parse(line string) (string, []Trigger) {
+	if !strings.Contains(line, "!!") {
+		return line, nil
+	}
+
+	var out strings.Builder
+	var triggers []Trigger
+	rest := line
+	for {
+		i := strings.Index(rest, "!!")
+		if i < 0 {
+			break
+		}
+		trigger, n, ok := parseTrigger(rest[i:])
+		if !ok {
+			out.WriteString(rest[:i+1])
+			rest = rest[i+1:]
+			continue
+		}
+		out.WriteString(rest[:i])
+		triggers = append(triggers, trigger)
+		rest = rest[i+n:]
+	}
+	if len(triggers) == 0 {
+		return line, nil
+	}
+	out.WriteString(rest)
+
+	text := out.String()
+	if strings.TrimSpace(text) == "" {
+		return "", triggers
+	}
+	return text, triggers
+}
+
+// parseTrigger parses a trigger at the start of s, returning its length
+func parseTrigger(s string) (Trigger, int, bool) {
+	var t Trigger
+	switch {
+	case strings.HasPrefix(s, "!!SOUND("):
+		t = Trigger{Volume: 100, Loops: 1, Priority: 50}
+	case strings.HasPrefix(s, "!!MUSIC("):
+		t = Trigger{Music: true, Volume: 100, Loops: 1, Continue: true}
+	default:
+		return t, 0, false
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return t, 0, false
+	}
+	fields := strings.Fields(s[len("!!SOUND("):end])
+	if len(fields) == 0 {
+		return t, 0, false
+	}
+
+	t.File = fields[0]
+	t.Stop = strings.EqualFold(t.File, "Off")
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		switch strings.ToUpper(key) {
+		case "V":
+			if err == nil && n >= 0 && n <= 100 {
+				t.Volume = n
+			}
+		case "L":
+			if err == nil && (n == -1 || n > 0) {
+				t.Loops = n
+			}
+		case "P":
+			if !t.Music && err == nil && n >= 0 && n <= 100 {
+				t.Priority = n
+			}
+		case "C":
+			if t.Music && err == nil {
+				t.Continue = n != 0
+			}
+		case "T":
+			t.Type = value
+		case "U":
+			t.URL = value
+		}
+	}
+	return t, end + 1, true
+}
@@ -0,0 +1,94 @@
+package msp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		text     string
+		triggers []Trigger
+	}{
+		{
+			name: "plain text",
+			line: "You hear a distant bell!!",
+			text: "You hear a distant bell!!",
+		},
+		{
+			name: "sound with defaults",
+			line: "!!SOUND(weather/rain.wav)",
+			triggers: []Trigger{
+				{File: "weather/rain.wav", Volume: 100, Loops: 1, Priority: 50},
+			},
+		},
+		{
+			name: "sound with parameters",
+			line: "!!SOUND(hit.wav V=80 L=2 P=90 T=combat U=http://example.com/sounds/)",
+			triggers: []Trigger{
+				{File: "hit.wav", Volume: 80, Loops: 2, Priority: 90, Type: "combat", URL: "http://example.com/sounds/"},
+			},
+		},
+		{
+			name: "music",
+			line: "!!MUSIC(town.mid L=-1 C=0 v=50)",
+			triggers: []Trigger{
+				{Music: true, File: "town.mid", Volume: 50, Loops: -1},
+			},
+		},
+		{
+			name: "stop",
+			line: "!!MUSIC(Off)",
+			triggers: []Trigger{
+				{Music: true, File: "Off", Stop: true, Volume: 100, Loops: 1, Continue: true},
+			},
+		},
+		{
+			name: "invalid values keep defaults",
+			line: "!!SOUND(x.wav V=150 L=0 P=abc C=0)",
+			triggers: []Trigger{
+				{File: "x.wav", Volume: 100, Loops: 1, Priority: 50},
+			},
+		},
+		{
+			name: "inside text",
+			line: "The orc hits you!!!SOUND(hit.wav) Ouch.",
+			text: "The orc hits you! Ouch.",
+			triggers: []Trigger{
+				{File: "hit.wav", Volume: 100, Loops: 1, Priority: 50},
+			},
+		},
+		{
+			name: "several triggers",
+			line: "!!SOUND(a.wav) !!MUSIC(b.mid)",
+			triggers: []Trigger{
+				{File: "a.wav", Volume: 100, Loops: 1, Priority: 50},
+				{Music: true, File: "b.mid", Volume: 100, Loops: 1, Continue: true},
+			},
+		},
+		{
+			name: "unterminated",
+			line: "!!SOUND(rain.wav",
+			text: "!!SOUND(rain.wav",
+		},
+		{
+			name: "empty",
+			line: "Say !!SOUND() to test",
+			text: "Say !!SOUND() to test",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, triggers := Parse(tt.line)
+			if text != tt.text {
+				t.Errorf("expected text %q, got %q", tt.text, text)
+			}
+			if !reflect.DeepEqual(triggers, tt.triggers) {
+				t.Errorf("expected triggers %+v, got %+v", tt.triggers, triggers)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package mxp
+
+import (
+	"fmt"
+	"strings"
+)
+
+const ansiReset = "\x1b[0m"
+
+// openTags are the elements allowed in open mode
+var openTags = map[string]bool{
+	"b": true, "bold": true, "strong": true,
+	"i": true, "italic": true, "em": true,
+	"u": true, "underline": true,
+	"s": true, "strikeout": true,
+	"c": true, "color": true,
+	"h": true, "high": true,
+	"font": true, "nobr": true, "p": true, "small": true, "tt": true,
+}
+
+// supported is the list of elements reported in response to <SUPPORT>
+const supported = "+b +i +u +s +c +h +font +nobr +p +small +tt +br +send +a +version +support +!element +!entity"
+
+// attr is an attribute in a tag. Positional attributes have no key.
+type attr struct {
+	key    string
+	value  string
+	quoted bool
+}
+
+// attrDef is an attribute declared by a custom element
+type attrDef struct {
+	name  string
+	value string // Default value
+}
+
+// element is a custom element defined with <!ELEMENT>
+type element struct {
+	definition string
+	attributes []attrDef
+	open       bool
+	empty      bool
+}
+
+// parseTag splits the body of a tag into its name and attributes
+func parseTag(body string) (string, []attr) {
+	tokens := tokenize(body)
+	if len(tokens) == 0 {
+		return "", nil
+	}
+
+	attrs := make([]attr, 0, len(tokens)-1)
+	for _, tok := range tokens[1:] {
+		switch {
+		case tok[0] == '"' || tok[0] == '\'':
+			attrs = append(attrs, attr{value: unquote(tok), quoted: true})
+		case strings.Contains(tok, "="):
+			key, value, _ := strings.Cut(tok, "=")
+			attrs = append(attrs, attr{key: strings.ToLower(key), value: unquote(value), quoted: true})
+		default:
+			attrs = append(attrs, attr{value: tok})
+		}
+	}
+	return tokens[0], attrs
+}
+
+// tokenize splits on whitespace, keeping quoted strings (and their quotes)
+// together.
+func tokenize(s string) []string {
+	var tokens []string
+	start := -1
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			if start < 0 {
+				start = i
+			}
+		case c == ' ' || c == '\t':
+			if start >= 0 {
+				tokens = append(tokens, s[start:i])
+				start = -1
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// resolveAttrs matches attributes to the given names, in order for
+// positional ones. Unquoted positional attributes that match a name are
+// treated as flags and given the value "1".
+func resolveAttrs(attrs []attr, names []string, defaults map[string]string) map[string]string {
+	values := make(map[string]string, len(names))
+	for name, value := range defaults {
+		values[name] = value
+	}
+
+	assigned := make(map[string]bool, len(names))
+	pos := 0
+	for _, a := range attrs {
+		if a.key != "" {
+			values[a.key] = a.value
+			assigned[a.key] = true
+			continue
+		}
+		if !a.quoted {
+			flag := strings.ToLower(a.value)
+			if contains(names, flag) {
+				values[flag] = "1"
+				assigned[flag] = true
+				continue
+			}
+		}
+		for pos < len(names) && assigned[names[pos]] {
+			pos++
+		}
+		if pos < len(names) {
+			values[names[pos]] = a.value
+			assigned[names[pos]] = true
+			pos++
+		}
+	}
+	return values
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// openTag processes a built-in element
+func (p *Parser) openTag(st *lineState, name string, attrs []attr) {
+	f := frame{name: name, link: -1}
+
+	switch name {
+	case "b", "bold", "strong", "h", "high":
+		f.style = "\x1b[1m"
+	case "i", "italic", "em":
+		f.style = "\x1b[3m"
+	case "u", "underline":
+		f.style = "\x1b[4m"
+	case "s", "strikeout":
+		f.style = "\x1b[9m"
+	case "c", "color":
+		values := resolveAttrs(attrs, []string{"fore", "back"}, nil)
+		f.style = colorStyle(values["fore"], values["back"])
+	case "font":
+		values := resolveAttrs(attrs, []string{"face", "size", "color", "back"}, nil)
+		f.style = colorStyle(values["color"], values["back"])
+	case "send":
+		values := resolveAttrs(attrs, []string{"href", "hint", "prompt", "expire"}, nil)
+		f.style = "\x1b[4m"
+		link := Link{Prompt: values["prompt"] != ""}
+		if values["href"] != "" {
+			link.Commands = strings.Split(values["href"], "|")
+		}
+		if values["hint"] != "" {
+			link.Hints = strings.Split(values["hint"], "|")
+		}
+		f.link = len(st.links)
+		st.links = append(st.links, link)
+	case "a":
+		values := resolveAttrs(attrs, []string{"href", "hint", "expire"}, nil)
+		f.style = "\x1b[4m"
+		link := Link{URL: values["href"]}
+		if values["hint"] != "" {
+			link.Hints = []string{values["hint"]}
+		}
+		f.link = len(st.links)
+		st.links = append(st.links, link)
+	case "br":
+		st.out.WriteByte('\n')
+		return
+	case "version":
+		st.reply("<VERSION MXP=1.0 CLIENT=%s VERSION=%s>", p.client, p.version)
+		return
+	case "support":
+		st.reply("<SUPPORTS %s>", supported)
+		return
+	case "nobr", "p", "small", "tt":
+		// Accepted but have no effect on a terminal
+	default:
+		// Unknown elements are dropped
+		return
+	}
+
+	if f.style != "" {
+		st.out.WriteString(f.style)
+	}
+	if f.link >= 0 {
+		st.links[f.link].Start = st.out.Len()
+	}
+	st.stack = append(st.stack, f)
+}
+
+// define handles <!ELEMENT> and <!ENTITY> definitions
+func (p *Parser) define(body string) {
+	kind, attrs := parseTag(body)
+	values := resolveAttrs(attrs, []string{"name", "definition", "att", "tag", "flag", "open", "delete", "empty"}, nil)
+	name := strings.ToLower(values["name"])
+	if name == "" {
+		return
+	}
+
+	switch strings.ToUpper(kind) {
+	case "ELEMENT", "EL":
+		if values["delete"] != "" {
+			delete(p.elements, name)
+			return
+		}
+		p.elements[name] = &element{
+			definition: values["definition"],
+			attributes: parseAttrDefs(values["att"]),
+			open:       values["open"] != "",
+			empty:      values["empty"] != "",
+		}
+	case "ENTITY", "EN":
+		if values["delete"] != "" {
+			delete(p.entities, name)
+			return
+		}
+		p.entities[name] = values["definition"]
+	}
+}
+
+// parseAttrDefs parses the ATT list of an element, e.g. "name desc=none"
+func parseAttrDefs(s string) []attrDef {
+	var defs []attrDef
+	for _, tok := range tokenize(s) {
+		name, value, _ := strings.Cut(tok, "=")
+		defs = append(defs, attrDef{name: strings.ToLower(name), value: unquote(value)})
+	}
+	return defs
+}
+
+// expand processes a custom element, whose content starts at next. It
+// returns the index to continue parsing from.
+func (p *Parser) expand(st *lineState, s string, next int, name string, el *element, attrs []attr, empty bool) int {
+	names := make([]string, len(el.attributes))
+	defaults := make(map[string]string, len(el.attributes))
+	for i, def := range el.attributes {
+		names[i] = def.name
+		defaults[def.name] = def.value
+	}
+	values := resolveAttrs(attrs, names, defaults)
+
+	content := ""
+	resume := next
+	if !el.empty && !empty {
+		closing := "</" + name + ">"
+		if idx := strings.Index(strings.ToLower(s[next:]), closing); idx >= 0 {
+			content = s[next : next+idx]
+			resume = next + idx + len(closing)
+		} else {
+			content = s[next:]
+			resume = len(s)
+		}
+	}
+
+	definition := el.definition
+	for _, n := range names {
+		definition = replaceRef(definition, n, values[n])
+	}
+	definition = replaceRef(definition, "text", p.plainText(content))
+
+	p.parse(st, definition, true)
+	p.parse(st, content, false)
+	p.parse(st, closingTags(definition), true)
+	return resume
+}
+
+// replaceRef replaces references to an attribute (&name;) in a definition,
+// ignoring case.
+func replaceRef(definition, name, value string) string {
+	ref := "&" + name + ";"
+	var b strings.Builder
+	for {
+		idx := strings.Index(strings.ToLower(definition), ref)
+		if idx < 0 {
+			b.WriteString(definition)
+			return b.String()
+		}
+		b.WriteString(definition[:idx])
+		b.WriteString(value)
+		definition = definition[idx+len(ref):]
+	}
+}
+
+// closingTags returns the tags closing every element opened in definition,
+// innermost first.
+func closingTags(definition string) string {
+	var names []string
+	for i := 0; i < len(definition); i++ {
+		if definition[i] != '<' {
+			continue
+		}
+		end := findTagEnd(definition, i+1)
+		if end < 0 {
+			continue
+		}
+		body := definition[i+1 : end]
+		if !strings.HasPrefix(body, "/") && !strings.HasPrefix(body, "!") && !strings.HasSuffix(body, "/") {
+			name, _ := parseTag(body)
+			names = append(names, name)
+		}
+		i = end
+	}
+
+	var b strings.Builder
+	for i := len(names) - 1; i >= 0; i-- {
+		b.WriteString("</" + names[i] + ">")
+	}
+	return b.String()
+}
+
+// plainText returns the text of markup with tags, entities and formatting
+// removed.
+func (p *Parser) plainText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '<':
+			if end := findTagEnd(s, i+1); end >= 0 {
+				i = end + 1
+				continue
+			}
+		case '&':
+			if value, n := p.decodeEntity(s[i:]); n > 0 {
+				b.WriteString(value)
+				i += n
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return stripANSI(b.String())
+}
+
+// basicColors maps the HTML colour names to their nearest ANSI colours
+var basicColors = map[string]int{
+	"black": 0, "maroon": 1, "green": 2, "olive": 3,
+	"navy": 4, "purple": 5, "teal": 6, "silver": 7,
+	"gray": 60, "grey": 60, "red": 61, "lime": 62, "yellow": 63,
+	"blue": 64, "fuchsia": 65, "magenta": 65, "aqua": 66, "cyan": 66, "white": 67,
+}
+
+// colorStyle returns the ANSI sequence for a foreground and background
+// colour, given as names or #RRGGBB.
+func colorStyle(fore, back string) string {
+	var codes []string
+	if code := colorCode(fore, 30); code != "" {
+		codes = append(codes, code)
+	}
+	if code := colorCode(back, 40); code != "" {
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+func colorCode(color string, base int) string {
+	color = strings.ToLower(strings.TrimSpace(color))
+	if color == "" {
+		return ""
+	}
+	if offset, ok := basicColors[color]; ok {
+		return fmt.Sprint(base + offset)
+	}
+
+	var r, g, b int
+	if len(color) == 7 && color[0] == '#' {
+		if _, err := fmt.Sscanf(color, "#%02x%02x%02x", &r, &g, &b); err == nil {
+			return fmt.Sprintf("%d;2;%d;%d;%d", base+8, r, g, b)
+		}
+	}
+	return ""
+}
+
+// stripANSI removes ANSI escape sequences from s
+func stripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '[' {
+			j := i + 2
+			for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+				j++
+			}
+			i = j
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package mxp
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtinEntities are the entities every MXP client understands
+var builtinEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"quot": "\"",
+	"apos": "'",
+	"nbsp": " ",
+}
+
+// decodeEntity decodes the entity at the start of s, returning its value and
+// length, or a length of 0 if s doesn't start with a known entity.
+func (p *Parser) decodeEntity(s string) (string, int) {
+	end := strings.IndexByte(s, ';')
+	if end < 2 || end > 32 {
+		return "", 0
+	}
+	name := s[1:end]
+
+	if name[0] == '#' {
+		var code int64
+		var err error
+		if len(name) > 1 && (name[1] == 'x' || name[1] == 'X') {
+			code, err = strconv.ParseInt(name[2:], 16, 32)
+		} else {
+			code, err = strconv.ParseInt(name[1:], 10, 32)
+		}
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return "", 0
+		}
+		return string(rune(code)), end + 1
+	}
+
+	name = strings.ToLower(name)
+	if value, ok := p.entities[name]; ok {
+		return value, end + 1
+	}
+	if value, ok := builtinEntities[name]; ok {
+		return value, end + 1
+	}
+	return "", 0
+}
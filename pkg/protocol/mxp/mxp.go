@@ -0,0 +1,302 @@
+// Package mxp implements the MUD eXtension Protocol: line modes, built-in
+// and custom elements, entities and links.
+package mxp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mode is an MXP line mode, controlling which tags are processed
+type Mode int
+
+const (
+	ModeOpen   Mode = iota // Only open (formatting) tags are processed
+	ModeSecure             // All tags are processed
+	ModeLocked             // No tags are processed, text is shown as is
+)
+
+// Link is a clickable span of text within a line
+type Link struct {
+	Start    int      // Byte offset of the link text in Line.Text
+	End      int      // Byte offset just past the link text
+	Text     string   // Link text without formatting
+	Commands []string // Commands to send, the first is the default action
+	Hints    []string // Tooltip and menu labels for the commands
+	URL      string   // Target of an <A> link
+	Prompt   bool     // Commands should be offered for editing, not sent
+}
+
+// Line is a line of output with MXP processed
+type Line struct {
+	Text    string   // Text with tags removed and formatting as ANSI sequences
+	Links   []Link   // Links found in the line
+	Replies []string // Data the client should send back to the server
+}
+
+// Parser processes MXP markup one line at a time. Modes, custom elements
+// and entities persist across lines.
+type Parser struct {
+	defaultMode Mode
+	elements    map[string]*element
+	entities    map[string]string
+
+	// Client name and version given in answer to <VERSION>
+	client  string
+	version string
+}
+
+// New returns a parser in the default (open) mode for the named client
+func New(client, version string) *Parser {
+	return &Parser{
+		client:      client,
+		version:     version,
+		defaultMode: ModeOpen,
+		elements:    make(map[string]*element),
+		entities:    make(map[string]string),
+	}
+}
+
+// lineState holds the state of the line being parsed
+type lineState struct {
+	out        strings.Builder
+	mode       Mode
+	tempSecure bool
+	stack      []frame
+	links      []Link
+	replies    []string
+}
+
+// frame is an open element on the stack
+type frame struct {
+	name  string
+	style string // ANSI sequence applied by the element, if any
+	link  int    // Index into links for <send> and <a>, or -1
+}
+
+// Parse processes one line of output, without its line ending
+func (p *Parser) Parse(line string) Line {
+	st := &lineState{mode: p.defaultMode}
+	p.parse(st, line, false)
+
+	// Tags don't carry over to the next line
+	if len(st.stack) > 0 {
+		for i := len(st.stack) - 1; i >= 0; i-- {
+			p.closeFrame(st, i)
+		}
+		st.stack = nil
+	}
+
+	return Line{Text: st.out.String(), Links: st.links, Replies: st.replies}
+}
+
+// parse processes text, which is either server output or the expansion of a
+// custom element. Expansions are always trusted.
+func (p *Parser) parse(st *lineState, s string, trusted bool) {
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		if c == 0x1b {
+			if mode, n := parseModeSequence(s[i:]); n > 0 {
+				p.setMode(st, mode)
+				i += n
+				continue
+			}
+		}
+
+		if st.mode == ModeLocked && !trusted {
+			st.out.WriteByte(c)
+			i++
+			continue
+		}
+
+		switch c {
+		case '<':
+			end := findTagEnd(s, i+1)
+			if end < 0 {
+				// Not a tag, show it
+				st.out.WriteByte(c)
+				i++
+				continue
+			}
+			secure := trusted || st.mode == ModeSecure || st.tempSecure
+			st.tempSecure = false
+			i = p.handleTag(st, s, i, end, secure)
+		case '&':
+			if value, n := p.decodeEntity(s[i:]); n > 0 {
+				st.out.WriteString(value)
+				i += n
+				continue
+			}
+			st.out.WriteByte(c)
+			i++
+		default:
+			st.out.WriteByte(c)
+			i++
+		}
+	}
+}
+
+// parseModeSequence recognises ESC [ <n> z, returning the mode number and the
+// length of the sequence, or 0 if s doesn't start with one.
+func parseModeSequence(s string) (int, int) {
+	if len(s) < 4 || s[1] != '[' {
+		return 0, 0
+	}
+	n := 0
+	i := 2
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		n = n*10 + int(s[i]-'0')
+		i++
+	}
+	if i == 2 || i >= len(s) || s[i] != 'z' {
+		return 0, 0
+	}
+	return n, i + 1
+}
+
+func (p *Parser) setMode(st *lineState, mode int) {
+	switch mode {
+	case 0:
+		st.mode = ModeOpen
+	case 1:
+		st.mode = ModeSecure
+	case 2:
+		st.mode = ModeLocked
+	case 3:
+		// Reset: close everything and return to open mode
+		for i := len(st.stack) - 1; i >= 0; i-- {
+			p.closeFrame(st, i)
+		}
+		st.stack = nil
+		p.defaultMode = ModeOpen
+		st.mode = ModeOpen
+	case 4:
+		st.tempSecure = true
+	case 5:
+		p.defaultMode = ModeOpen
+		st.mode = ModeOpen
+	case 6:
+		p.defaultMode = ModeSecure
+		st.mode = ModeSecure
+	case 7:
+		p.defaultMode = ModeLocked
+		st.mode = ModeLocked
+	default:
+		// Line tags (10 and up) mark room names and the like; treat the
+		// line as secure so any elements on it work
+		st.mode = ModeSecure
+	}
+}
+
+// findTagEnd returns the index of the '>' closing a tag that starts at i,
+// skipping quoted strings, or -1 if there isn't one.
+func findTagEnd(s string, i int) int {
+	if i >= len(s) || !(isNameStart(s[i]) || s[i] == '/' || s[i] == '!') {
+		return -1
+	}
+	var quote byte
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i
+		}
+	}
+	return -1
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// handleTag processes the tag s[start:end+1] and returns the index to
+// continue parsing from.
+func (p *Parser) handleTag(st *lineState, s string, start, end int, secure bool) int {
+	body := s[start+1 : end]
+	next := end + 1
+
+	switch {
+	case strings.HasPrefix(body, "!"):
+		if secure {
+			p.define(body[1:])
+		}
+		return next
+	case strings.HasPrefix(body, "/"):
+		name := strings.ToLower(strings.TrimSpace(body[1:]))
+		p.closeTag(st, name)
+		return next
+	}
+
+	empty := strings.HasSuffix(body, "/")
+	body = strings.TrimSuffix(body, "/")
+	name, attrs := parseTag(body)
+	name = strings.ToLower(name)
+
+	if custom, ok := p.elements[name]; ok {
+		if !secure && !custom.open {
+			return next
+		}
+		return p.expand(st, s, next, name, custom, attrs, empty)
+	}
+
+	if !secure && !openTags[name] {
+		return next
+	}
+	p.openTag(st, name, attrs)
+	return next
+}
+
+// closeTag closes the most recent element with the given name along with any
+// elements opened after it.
+func (p *Parser) closeTag(st *lineState, name string) {
+	for i := len(st.stack) - 1; i >= 0; i-- {
+		if st.stack[i].name == name {
+			for j := len(st.stack) - 1; j >= i; j-- {
+				p.closeFrame(st, j)
+			}
+			st.stack = st.stack[:i]
+			p.restoreStyles(st)
+			return
+		}
+	}
+}
+
+// closeFrame finishes an element, completing its link if it has one
+func (p *Parser) closeFrame(st *lineState, i int) {
+	f := st.stack[i]
+	if f.link >= 0 {
+		link := &st.links[f.link]
+		link.End = st.out.Len()
+		link.Text = stripANSI(st.out.String()[link.Start:link.End])
+		if len(link.Commands) == 0 && link.URL == "" {
+			link.Commands = []string{link.Text}
+		}
+		for j, cmd := range link.Commands {
+			link.Commands[j] = strings.ReplaceAll(cmd, "&text;", link.Text)
+		}
+	}
+	if f.style != "" {
+		st.out.WriteString(ansiReset)
+	}
+}
+
+// restoreStyles reapplies the styles of elements still open after a reset
+func (p *Parser) restoreStyles(st *lineState) {
+	for _, f := range st.stack {
+		if f.style != "" {
+			st.out.WriteString(f.style)
+		}
+	}
+}
+
+// reply queues a response to the server, sent as a secure line
+func (st *lineState) reply(format string, args ...interface{}) {
+	st.replies = append(st.replies, "\x1b[1z"+fmt.Sprintf(format, args...)+"\n")
+}
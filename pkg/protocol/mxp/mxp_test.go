@@ -0,0 +1,161 @@
+package mxp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string // Earlier lines fed to the same parser
+		line  string
+		want  string
+	}{
+		{
+			name: "plain text",
+			line: "You are standing in a field.",
+			want: "You are standing in a field.",
+		},
+		{
+			name: "open tags in open mode",
+			line: "A <b>bold</b> and <color red>red</color> word",
+			want: "A \x1b[1mbold\x1b[0m and \x1b[91mred\x1b[0m word",
+		},
+		{
+			name: "secure tags ignored in open mode",
+			line: "Go <send>north</send>",
+			want: "Go north",
+		},
+		{
+			name: "locked line shows tags",
+			line: "\x1b[2z<b>raw</b> &amp;",
+			want: "<b>raw</b> &amp;",
+		},
+		{
+			name: "entities",
+			line: "&lt;tag&gt; &amp; &quot;q&quot; &#65;&#x42; &bogus;",
+			want: "<tag> & \"q\" AB &bogus;",
+		},
+		{
+			name: "comparison is not a tag",
+			line: "hp < 10 > 5",
+			want: "hp < 10 > 5",
+		},
+		{
+			name: "hex colour",
+			line: "<c fore=#ff8000 back=navy>x</c>",
+			want: "\x1b[38;2;255;128;0;44mx\x1b[0m",
+		},
+		{
+			name: "unclosed tags end with the line",
+			line: "<u>under",
+			want: "\x1b[4munder\x1b[0m",
+		},
+		{
+			name: "nested tags restore styles",
+			line: "<b>a<i>b</b>c",
+			want: "\x1b[1ma\x1b[3mb\x1b[0m\x1b[0mc",
+		},
+		{
+			name:  "line mode resets at newline",
+			lines: []string{"\x1b[1z<!ENTITY hero 'Bob'>"},
+			line:  "<send>x</send> &hero;",
+			want:  "x Bob",
+		},
+		{
+			name:  "locked default mode",
+			lines: []string{"\x1b[7z"},
+			line:  "<b>literal</b>",
+			want:  "<b>literal</b>",
+		},
+		{
+			name:  "reset returns to open mode",
+			lines: []string{"\x1b[6z", "\x1b[3z"},
+			line:  "<send>x</send>",
+			want:  "x",
+		},
+		{
+			name: "temp secure applies to one tag",
+			line: "\x1b[4z<send>a</send> <send>b</send>",
+			want: "\x1b[4ma\x1b[0m b",
+		},
+		{
+			name:  "custom element",
+			lines: []string{"\x1b[1z<!ELEMENT Hp '<color &col;><b>' ATT='col=red' OPEN>"},
+			line:  "<hp>50</hp> <hp col=lime>60</hp>",
+			want:  "\x1b[91m\x1b[1m50\x1b[0m\x1b[91m\x1b[0m \x1b[92m\x1b[1m60\x1b[0m\x1b[92m\x1b[0m",
+		},
+		{
+			name:  "secure custom element ignored in open mode",
+			lines: []string{"\x1b[1z<!ELEMENT Ex '<send>'>"},
+			line:  "<ex>north</ex>",
+			want:  "north",
+		},
+		{
+			name:  "deleted element",
+			lines: []string{"\x1b[1z<!EL Hp '<b>' OPEN>", "\x1b[1z<!EL Hp DELETE>"},
+			line:  "<hp>50</hp>",
+			want:  "50",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New("Runes", "1.0.0")
+			for _, line := range tt.lines {
+				p.Parse(line)
+			}
+			got := p.Parse(tt.line)
+			if got.Text != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got.Text)
+			}
+		})
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	p := New("Runes", "1.0.0")
+	p.Parse("\x1b[6z")
+	p.Parse("<!ELEMENT Ex '<send href=\"go &text;\" hint=\"Go &text;\">'>")
+
+	got := p.Parse(`Exits: <ex>north</ex> <send "get sword|look sword" "Sword|Get|Look">a sword</send> <send prompt>say hi</send> <a href="https://example.com">site</a>`)
+
+	want := []Link{
+		{Text: "north", Commands: []string{"go north"}, Hints: []string{"Go north"}},
+		{Text: "a sword", Commands: []string{"get sword", "look sword"}, Hints: []string{"Sword", "Get", "Look"}},
+		{Text: "say hi", Commands: []string{"say hi"}, Prompt: true},
+		{Text: "site", URL: "https://example.com"},
+	}
+	if len(got.Links) != len(want) {
+		t.Fatalf("expected %d links, got %d: %+v", len(want), len(got.Links), got.Links)
+	}
+	for i, link := range got.Links {
+		if got.Text[link.Start:link.End] != link.Text {
+			t.Errorf("link %d: span %q doesn't match text %q", i, got.Text[link.Start:link.End], link.Text)
+		}
+		link.Start, link.End = 0, 0
+		if !reflect.DeepEqual(link, want[i]) {
+			t.Errorf("link %d: expected %+v, got %+v", i, want[i], link)
+		}
+	}
+}
+
+func TestParseReplies(t *testing.T) {
+	p := New("Runes", "1.0.0")
+	got := p.Parse("\x1b[1z<VERSION><SUPPORT>")
+	if got.Text != "" {
+		t.Errorf("expected no text, got %q", got.Text)
+	}
+	if len(got.Replies) != 2 {
+		t.Fatalf("expected 2 replies, got %q", got.Replies)
+	}
+	if want := "\x1b[1z<VERSION MXP=1.0 CLIENT=Runes VERSION=1.0.0>\n"; got.Replies[0] != want {
+		t.Errorf("expected %q, got %q", want, got.Replies[0])
+	}
+
+	// Requests in open mode are ignored
+	if got := p.Parse("<VERSION>"); len(got.Replies) != 0 {
+		t.Errorf("expected no replies in open mode, got %q", got.Replies)
+	}
+}
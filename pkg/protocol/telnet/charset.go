@@ -0,0 +1,227 @@
+package telnet
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// CHARSET subnegotiation commands (RFC 2066)
+const (
+	charsetREQUEST         = 1
+	charsetACCEPTED        = 2
+	charsetREJECTED        = 3
+	charsetTTABLE_IS       = 4
+	charsetTTABLE_REJECTED = 5
+)
+
+// Supported encodings
+const (
+	EncodingUTF8        = "UTF-8"
+	EncodingLatin1      = "ISO-8859-1"
+	EncodingCP437       = "CP437"
+	EncodingWindows1252 = "WINDOWS-1252"
+)
+
+// encodings maps the names an encoding may go by to its canonical name
+var encodings = map[string]string{
+	"UTF-8":        EncodingUTF8,
+	"UTF8":         EncodingUTF8,
+	"US-ASCII":     EncodingUTF8,
+	"ASCII":        EncodingUTF8,
+	"ISO-8859-1":   EncodingLatin1,
+	"ISO8859-1":    EncodingLatin1,
+	"ISO_8859-1":   EncodingLatin1,
+	"LATIN1":       EncodingLatin1,
+	"LATIN-1":      EncodingLatin1,
+	"CP437":        EncodingCP437,
+	"IBM437":       EncodingCP437,
+	"WINDOWS-1252": EncodingWindows1252,
+	"CP1252":       EncodingWindows1252,
+}
+
+// charmaps holds the tables for the single byte encodings
+var charmaps = map[string]*charmap.Charmap{
+	EncodingLatin1:      charmap.ISO8859_1,
+	EncodingCP437:       charmap.CodePage437,
+	EncodingWindows1252: charmap.Windows1252,
+}
+
+// charsetOffer lists the encodings offered to the server, most preferred
+// first
+var charsetOffer = []string{EncodingUTF8, EncodingLatin1, EncodingWindows1252, EncodingCP437}
+
+// CharsetEvent reports the server agreeing on an encoding with CHARSET
+type CharsetEvent struct {
+	Encoding string
+}
+
+// LookupEncoding returns the canonical name of an encoding, or false if it
+// isn't supported
+func LookupEncoding(name string) (string, bool) {
+	canonical, ok := encodings[strings.ToUpper(strings.TrimSpace(name))]
+	return canonical, ok
+}
+
+// SetEncoding sets the encoding of text on the connection. Text read from
+// the connection is decoded to UTF-8 and Encode converts text to send.
+func (t *TelnetConnection) SetEncoding(name string) error {
+	canonical, ok := LookupEncoding(name)
+	if !ok {
+		return fmt.Errorf("unsupported encoding %q", name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.encoding = canonical
+	t.environChanged()
+	return nil
+}
+
+// Encoding returns the encoding of text on the connection
+func (t *TelnetConnection) Encoding() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.encoding
+}
+
+// Encode converts UTF-8 text to the connection's encoding. Characters the
+// encoding can't represent are sent as '?'.
+func (t *TelnetConnection) Encode(text string) []byte {
+	t.mu.Lock()
+	cm := charmaps[t.encoding]
+	t.mu.Unlock()
+
+	if cm == nil {
+		return []byte(text)
+	}
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		b, ok := cm.EncodeRune(r)
+		if !ok {
+			b = '?'
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// charmap returns the table for decoding the connection's encoding, or nil
+// for UTF-8
+func (t *TelnetConnection) charmap() *charmap.Charmap {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return charmaps[t.encoding]
+}
+
+// decodeByte writes b to dst as UTF-8, decoding it with cm unless that is
+// nil, and returns the number of bytes written. It returns 0 if dst is too
+// small, unless first is set, in which case what doesn't fit is kept in
+// t.spill for the next Read.
+func (t *TelnetConnection) decodeByte(dst []byte, b byte, cm *charmap.Charmap, first bool) int {
+	if len(dst) == 0 {
+		return 0
+	}
+	if cm == nil || b < utf8.RuneSelf {
+		// All supported encodings agree with ASCII
+		dst[0] = b
+		return 1
+	}
+
+	var enc [utf8.UTFMax]byte
+	size := utf8.EncodeRune(enc[:], cm.DecodeByte(b))
+	if size <= len(dst) {
+		return copy(dst, enc[:size])
+	}
+	if !first {
+		return 0
+	}
+	n := copy(dst, enc[:size])
+	t.spill = t.spillBuf[:copy(t.spillBuf[:], enc[n:size])]
+	return n
+}
+
+// sendCharsetRequest offers the supported encodings once the server agrees
+// to CHARSET. Called with t.mu held.
+func (t *TelnetConnection) sendCharsetRequest() {
+	msg := []byte{cmdIAC, cmdSB, optCHARSET, charsetREQUEST}
+	for _, name := range charsetOffer {
+		msg = append(msg, ';')
+		msg = append(msg, name...)
+	}
+	msg = append(msg, cmdIAC, cmdSE)
+	t.send(msg)
+}
+
+// handleCharset answers CHARSET subnegotiations
+func (t *TelnetConnection) handleCharset(data []byte) []TelnetEvent {
+	if len(data) == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch data[0] {
+	case charsetREQUEST:
+		name, ok := chooseCharset(data[1:])
+		if !ok {
+			t.send([]byte{cmdIAC, cmdSB, optCHARSET, charsetREJECTED, cmdIAC, cmdSE})
+			return nil
+		}
+		msg := []byte{cmdIAC, cmdSB, optCHARSET, charsetACCEPTED}
+		msg = appendEscaped(msg, []byte(name))
+		msg = append(msg, cmdIAC, cmdSE)
+		t.send(msg)
+		return t.charsetAgreed(name)
+	case charsetACCEPTED:
+		return t.charsetAgreed(string(data[1:]))
+	case charsetTTABLE_IS:
+		// Translation tables aren't supported
+		t.send([]byte{cmdIAC, cmdSB, optCHARSET, charsetTTABLE_REJECTED, cmdIAC, cmdSE})
+	}
+	return nil
+}
+
+// charsetAgreed switches to an encoding agreed with the server. Called with
+// t.mu held.
+func (t *TelnetConnection) charsetAgreed(name string) []TelnetEvent {
+	canonical, ok := LookupEncoding(name)
+	if !ok {
+		return nil
+	}
+	t.encoding = canonical
+	t.environChanged()
+	return []TelnetEvent{CharsetEvent{Encoding: canonical}}
+}
+
+// chooseCharset picks an encoding from the list in a CHARSET REQUEST,
+// preferring UTF-8 and otherwise taking the server's order. It returns the
+// name as the server gave it.
+func chooseCharset(list []byte) (string, bool) {
+	// Skip the translation table version, tables aren't supported
+	if rest, ok := bytes.CutPrefix(list, []byte("[TTABLE]")); ok && len(rest) > 0 {
+		list = rest[1:]
+	}
+	if len(list) < 2 {
+		return "", false
+	}
+
+	var choice string
+	for _, name := range strings.Split(string(list[1:]), string(list[0])) {
+		canonical, ok := LookupEncoding(name)
+		if !ok {
+			continue
+		}
+		if canonical == EncodingUTF8 {
+			return name, true
+		}
+		if choice == "" {
+			choice = name
+		}
+	}
+	return choice, choice != ""
+}
@@ -0,0 +1,137 @@
+package telnet
+
+import (
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		encoding string
+		data     string
+		want     string
+	}{
+		{"UTF-8", "caf\xc3\xa9", "café"},
+		{"latin1", "caf\xe9 \xa3", "café £"},
+		{"CP437", "\xc9\xcd\xbb\r\n\xba\xb0\xba", "╔═╗\r\n║░║"},
+		{"windows-1252", "\x93quoted\x94 \x80", "“quoted” €"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			client, server := dialTestServer(t)
+			if err := client.SetEncoding(tt.encoding); err != nil {
+				t.Fatal("SetEncoding failed:", err)
+			}
+			go func() {
+				server.Write([]byte(tt.data))
+				server.Close()
+			}()
+
+			// Read in small pieces so decoded text overflows the buffer
+			var got []byte
+			buf := make([]byte, 3)
+			for {
+				n, err := client.Read(buf)
+				got = append(got, buf[:n]...)
+				if err != nil {
+					break
+				}
+			}
+			if string(got) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	client, _ := dialTestServer(t)
+
+	if got := client.Encode("café ☃"); string(got) != "café ☃" {
+		t.Errorf("expected UTF-8 to pass through, got %q", got)
+	}
+
+	client.SetEncoding("ISO-8859-1")
+	if got, want := client.Encode("café ☃"), "caf\xe9 ?"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if err := client.SetEncoding("EBCDIC"); err == nil {
+		t.Error("expected an error for an unsupported encoding")
+	}
+	if got := client.Encoding(); got != EncodingLatin1 {
+		t.Errorf("expected encoding to stay %s, got %s", EncodingLatin1, got)
+	}
+}
+
+// charsetMsg builds a CHARSET subnegotiation
+func charsetMsg(cmd byte, data string) []byte {
+	msg := []byte{cmdIAC, cmdSB, optCHARSET, cmd}
+	msg = append(msg, data...)
+	return append(msg, cmdIAC, cmdSE)
+}
+
+func TestCharsetRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  string
+		reply    []byte
+		encoding string
+	}{
+		{"prefers UTF-8", ";ISO-8859-1;UTF-8", charsetMsg(charsetACCEPTED, "UTF-8"), EncodingUTF8},
+		{"server order", " x-unknown CP437 latin1", charsetMsg(charsetACCEPTED, "CP437"), EncodingCP437},
+		{"translation table version skipped", "[TTABLE]\x01;ISO-8859-1", charsetMsg(charsetACCEPTED, "ISO-8859-1"), EncodingLatin1},
+		{"nothing supported", ";KOI8-R;BIG5", charsetMsg(charsetREJECTED, ""), EncodingUTF8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := dialTestServer(t)
+
+			events := make(chan TelnetEvent, 10)
+			client.SetEventHandler(func(e TelnetEvent) {
+				if e, ok := e.(CharsetEvent); ok {
+					events <- e
+				}
+			})
+			go readAll(client)
+
+			server.Write([]byte{cmdIAC, cmdDO, optCHARSET})
+			server.Write(charsetMsg(charsetREQUEST, tt.request))
+			want := append([]byte{cmdIAC, cmdWILL, optCHARSET}, tt.reply...)
+			if !expectBytes(t, server, want) {
+				return
+			}
+			if got := client.Encoding(); got != tt.encoding {
+				t.Errorf("expected encoding %s, got %s", tt.encoding, got)
+			}
+			if tt.reply[3] == charsetACCEPTED {
+				if e := <-events; e != (CharsetEvent{Encoding: tt.encoding}) {
+					t.Errorf("expected event for %s, got %+v", tt.encoding, e)
+				}
+			}
+		})
+	}
+}
+
+func TestCharsetOffer(t *testing.T) {
+	client, server := dialTestServer(t)
+	go readAll(client)
+
+	// The server agreeing to CHARSET gets our list of encodings
+	server.Write([]byte{cmdIAC, cmdWILL, optCHARSET})
+	want := []byte{cmdIAC, cmdDO, optCHARSET}
+	want = append(want, charsetMsg(charsetREQUEST, ";UTF-8;ISO-8859-1;WINDOWS-1252;CP437")...)
+	if !expectBytes(t, server, want) {
+		return
+	}
+
+	server.Write(charsetMsg(charsetACCEPTED, "windows-1252"))
+	server.Write(charsetMsg(charsetTTABLE_IS, "\x01"))
+	if !expectBytes(t, server, charsetMsg(charsetTTABLE_REJECTED, "")) {
+		return
+	}
+	if got := client.Encoding(); got != EncodingWindows1252 {
+		t.Errorf("expected encoding %s, got %s", EncodingWindows1252, got)
+	}
+}
@@ -0,0 +1,203 @@
+package telnet
+
+import (
+	"net"
+	"sort"
+	"strconv"
+)
+
+// NEW-ENVIRON subnegotiation commands (RFC 1572)
+const (
+	environIS   = 0
+	environSEND = 1
+	environINFO = 2
+)
+
+// NEW-ENVIRON variable types and escape
+const (
+	environVAR     = 0
+	environVALUE   = 1
+	environESC     = 2
+	environUSERVAR = 3
+)
+
+// environKey identifies a NEW-ENVIRON variable. Well known variables, such as
+// the MNES ones, are VARs and custom ones are USERVARs.
+type environKey struct {
+	name string
+	user bool
+}
+
+// environVar is a variable reported with NEW-ENVIRON. A variable the server
+// asks for that isn't defined is reported without a value.
+type environVar struct {
+	environKey
+	value   string
+	defined bool
+}
+
+// SetEnvironVar sets a custom variable reported to the server with
+// NEW-ENVIRON. If the server has already asked for it, the new value is sent
+// immediately.
+func (t *TelnetConnection) SetEnvironVar(name, value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.userVars == nil {
+		t.userVars = make(map[string]string)
+	}
+	t.userVars[name] = value
+	t.environChanged()
+}
+
+// environment returns the variables reported with NEW-ENVIRON: the MNES
+// variables followed by custom ones. Called with t.mu held.
+func (t *TelnetConnection) environment() []environVar {
+	vars := []environVar{
+		{environKey{"CLIENT_NAME", false}, ClientName, true},
+		{environKey{"CLIENT_VERSION", false}, ClientVersion, true},
+		{environKey{"CHARSET", false}, t.encoding, true},
+		{environKey{"MTTS", false}, strconv.Itoa(t.mttsFlags()), true},
+		{environKey{"TERMINAL_TYPE", false}, t.terminalType(), true},
+	}
+	if host, _, err := net.SplitHostPort(t.conn.LocalAddr().String()); err == nil {
+		vars = append(vars, environVar{environKey{"IPADDRESS", false}, host, true})
+	}
+
+	names := make([]string, 0, len(t.userVars))
+	for name := range t.userVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vars = append(vars, environVar{environKey{name, true}, t.userVars[name], true})
+	}
+	return vars
+}
+
+// handleEnviron answers a NEW-ENVIRON SEND with the variables asked for, or
+// all of them if the request doesn't name any
+func (t *TelnetConnection) handleEnviron(data []byte) {
+	if len(data) == 0 || data[0] != environSEND {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	vars := t.environment()
+	requested := parseEnvironSend(data[1:])
+	if len(requested) == 0 {
+		requested = []environKey{{"", false}, {"", true}}
+	}
+
+	var reply []environVar
+	for _, key := range requested {
+		if key.name == "" {
+			// A type without a name asks for every variable of that type
+			for _, v := range vars {
+				if v.user == key.user {
+					reply = append(reply, v)
+				}
+			}
+			continue
+		}
+		v := environVar{environKey: key}
+		for _, known := range vars {
+			if known.environKey == key {
+				v = known
+				break
+			}
+		}
+		reply = append(reply, v)
+	}
+
+	if t.environSent == nil {
+		t.environSent = make(map[environKey]environVar)
+	}
+	for _, v := range reply {
+		t.environSent[v.environKey] = v
+	}
+	t.sendEnviron(environIS, reply)
+}
+
+// environChanged sends INFO for variables the server has asked for whose
+// values have changed. Called with t.mu held.
+func (t *TelnetConnection) environChanged() {
+	if !t.localEnabled(optNEW_ENVIRON) || len(t.environSent) == 0 {
+		return
+	}
+
+	var changed []environVar
+	for _, v := range t.environment() {
+		if sent, ok := t.environSent[v.environKey]; ok && sent != v {
+			changed = append(changed, v)
+			t.environSent[v.environKey] = v
+		}
+	}
+	if len(changed) > 0 {
+		t.sendEnviron(environINFO, changed)
+	}
+}
+
+// sendEnviron sends an IS or INFO message. Called with t.mu held.
+func (t *TelnetConnection) sendEnviron(cmd byte, vars []environVar) {
+	var payload []byte
+	for _, v := range vars {
+		if v.user {
+			payload = append(payload, environUSERVAR)
+		} else {
+			payload = append(payload, environVAR)
+		}
+		payload = appendEnvironString(payload, v.name)
+		if v.defined {
+			payload = append(payload, environVALUE)
+			payload = appendEnvironString(payload, v.value)
+		}
+	}
+
+	msg := []byte{cmdIAC, cmdSB, optNEW_ENVIRON, cmd}
+	msg = appendEscaped(msg, payload)
+	msg = append(msg, cmdIAC, cmdSE)
+	t.send(msg)
+}
+
+// appendEnvironString appends a name or value, escaping bytes that would
+// be read as a variable type
+func appendEnvironString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] <= environUSERVAR {
+			dst = append(dst, environESC)
+		}
+		dst = append(dst, s[i])
+	}
+	return dst
+}
+
+// parseEnvironSend returns the variables listed in a SEND request
+func parseEnvironSend(data []byte) []environKey {
+	var keys []environKey
+	var name []byte
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b == environVAR || b == environUSERVAR:
+			if len(keys) > 0 {
+				keys[len(keys)-1].name = string(name)
+			}
+			keys = append(keys, environKey{user: b == environUSERVAR})
+			name = name[:0]
+		case len(keys) == 0:
+			// Ignore anything before the first type
+		case b == environESC && i+1 < len(data):
+			i++
+			name = append(name, data[i])
+		default:
+			name = append(name, b)
+		}
+	}
+	if len(keys) > 0 {
+		keys[len(keys)-1].name = string(name)
+	}
+	return keys
+}
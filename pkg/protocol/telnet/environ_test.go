@@ -0,0 +1,85 @@
+package telnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+// environMsg builds a NEW-ENVIRON subnegotiation
+func environMsg(cmd byte, data ...byte) []byte {
+	msg := []byte{cmdIAC, cmdSB, optNEW_ENVIRON, cmd}
+	msg = append(msg, data...)
+	return append(msg, cmdIAC, cmdSE)
+}
+
+// environVars builds the payload for a list of VARs, given as name/value
+// pairs
+func environVars(pairs ...string) []byte {
+	var data []byte
+	for i := 0; i < len(pairs); i += 2 {
+		data = append(data, environVAR)
+		data = append(data, pairs[i]...)
+		data = append(data, environVALUE)
+		data = append(data, pairs[i+1]...)
+	}
+	return data
+}
+
+func TestParseEnvironSend(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []environKey
+	}{
+		{"everything", nil, nil},
+		{"all VARs", []byte{environVAR}, []environKey{{"", false}}},
+		{"named", []byte("\x00CHARSET\x03COLOR"), []environKey{{"CHARSET", false}, {"COLOR", true}}},
+		{"escaped", []byte("\x03A\x02\x01B"), []environKey{{"A\x01B", true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseEnvironSend(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	client, server := dialTestServer(t)
+	client.SetTerminalType("xterm", MTTSANSI)
+	client.SetEnvironVar("COLOR", "on")
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdDO, optNEW_ENVIRON})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdWILL, optNEW_ENVIRON}) {
+		return
+	}
+
+	// Named variables, one of them unknown, and every USERVAR
+	server.Write(environMsg(environSEND, []byte("\x00CHARSET\x00MTTS\x00HOME\x03")...))
+	want := environVars("CHARSET", "UTF-8", "MTTS", "513")
+	want = append(want, environVAR)
+	want = append(want, "HOME"...)
+	want = append(want, []byte("\x03COLOR\x01on")...)
+	if !expectBytes(t, server, environMsg(environIS, want...)) {
+		return
+	}
+
+	// Changes to variables the server asked for are sent as INFO
+	client.SetEncoding("latin1")
+	if !expectBytes(t, server, environMsg(environINFO, environVars("CHARSET", "ISO-8859-1")...)) {
+		return
+	}
+	client.SetEnvironVar("COLOR", "off")
+	if !expectBytes(t, server, environMsg(environINFO, []byte("\x03COLOR\x01off")...)) {
+		return
+	}
+
+	server.Write(environMsg(environSEND))
+	want = environVars("CLIENT_NAME", ClientName, "CLIENT_VERSION", ClientVersion, "CHARSET", "ISO-8859-1",
+		"MTTS", "513", "TERMINAL_TYPE", "XTERM", "IPADDRESS", "127.0.0.1")
+	want = append(want, []byte("\x03COLOR\x01off")...)
+	expectBytes(t, server, environMsg(environIS, want...))
+}
@@ -0,0 +1,88 @@
+package telnet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Client identification sent in the GMCP Core.Hello message
+const (
+	ClientName    = "Runes"
+	ClientVersion = "1.0.0"
+)
+
+// GMCPEvent is a GMCP message received from the server. Data holds the raw
+// JSON payload, which is empty for messages without one.
+type GMCPEvent struct {
+	Package string
+	Data    []byte
+}
+
+func (e GMCPEvent) String() string {
+	return fmt.Sprintf("GMCP %s %s", e.Package, e.Data)
+}
+
+// parseGMCP splits a GMCP payload into its package name and JSON data
+func parseGMCP(payload []byte) GMCPEvent {
+	pkg, data, _ := bytes.Cut(payload, []byte{' '})
+	return GMCPEvent{
+		Package: string(pkg),
+		Data:    bytes.TrimSpace(data),
+	}
+}
+
+// SendGMCP sends a GMCP message. data must be valid JSON or empty.
+func (t *TelnetConnection) SendGMCP(pkg string, data []byte) error {
+	msg := make([]byte, 0, len(pkg)+len(data)+6)
+	msg = append(msg, cmdIAC, cmdSB, optGMCP)
+	msg = appendEscaped(msg, []byte(pkg))
+	if len(data) > 0 {
+		msg = append(msg, ' ')
+		msg = appendEscaped(msg, data)
+	}
+	msg = append(msg, cmdIAC, cmdSE)
+	return t.send(msg)
+}
+
+// SetGMCPSupports sets the modules announced with Core.Supports.Set, e.g.
+// "Char 1". If GMCP is already active the new list is sent immediately.
+func (t *TelnetConnection) SetGMCPSupports(modules []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.gmcpSupports = append([]string(nil), modules...)
+	if t.remoteEnabled(optGMCP) {
+		t.sendGMCPSupports()
+	}
+}
+
+// sendGMCPHello identifies the client once the server agrees to GMCP
+func (t *TelnetConnection) sendGMCPHello() {
+	hello, _ := json.Marshal(struct {
+		Client  string `json:"client"`
+		Version string `json:"version"`
+	}{ClientName, ClientVersion})
+	t.SendGMCP("Core.Hello", hello)
+	t.sendGMCPSupports()
+}
+
+func (t *TelnetConnection) sendGMCPSupports() {
+	modules := t.gmcpSupports
+	if modules == nil {
+		modules = []string{}
+	}
+	data, _ := json.Marshal(modules)
+	t.SendGMCP("Core.Supports.Set", data)
+}
+
+// appendEscaped appends data to buf, doubling any IAC bytes
+func appendEscaped(buf, data []byte) []byte {
+	for _, b := range data {
+		if b == cmdIAC {
+			buf = append(buf, cmdIAC)
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
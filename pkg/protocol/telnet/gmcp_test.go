@@ -0,0 +1,90 @@
+package telnet
+
+import (
+	"testing"
+)
+
+func TestGMCPNegotiation(t *testing.T) {
+	client, server := dialTestServer(t)
+	client.SetGMCPSupports([]string{"Char 1", "Room 1"})
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdWILL, optGMCP})
+
+	want := []byte{cmdIAC, cmdDO, optGMCP}
+	want = append(want, cmdIAC, cmdSB, optGMCP)
+	want = append(want, []byte(`Core.Hello {"client":"Runes","version":"1.0.0"}`)...)
+	want = append(want, cmdIAC, cmdSE)
+	want = append(want, cmdIAC, cmdSB, optGMCP)
+	want = append(want, []byte(`Core.Supports.Set ["Char 1","Room 1"]`)...)
+	want = append(want, cmdIAC, cmdSE)
+	if !expectBytes(t, server, want) {
+		return
+	}
+
+	// Changing the list once GMCP is active announces it straight away
+	client.SetGMCPSupports([]string{"Char 1"})
+	want = []byte{cmdIAC, cmdSB, optGMCP}
+	want = append(want, []byte(`Core.Supports.Set ["Char 1"]`)...)
+	want = append(want, cmdIAC, cmdSE)
+	expectBytes(t, server, want)
+}
+
+func TestGMCPReceive(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	var received []GMCPEvent
+	client.SetEventHandler(func(e TelnetEvent) {
+		if msg, ok := e.(GMCPEvent); ok {
+			received = append(received, msg)
+		}
+	})
+
+	go func() {
+		msg := []byte("before\r\n")
+		msg = append(msg, cmdIAC, cmdSB, optGMCP)
+		msg = append(msg, []byte(`Char.Vitals {"hp": 100, "name": "x`)...)
+		msg = append(msg, cmdIAC, cmdIAC)
+		msg = append(msg, []byte(`"}`)...)
+		msg = append(msg, cmdIAC, cmdSE)
+		msg = append(msg, cmdIAC, cmdSB, optGMCP)
+		msg = append(msg, []byte("Core.Goodbye")...)
+		msg = append(msg, cmdIAC, cmdSE)
+		msg = append(msg, []byte("after\r\n")...)
+		server.Write(msg)
+		server.Close()
+	}()
+
+	got, err := readAll(client)
+	if err != nil {
+		t.Fatal("Read failed:", err)
+	}
+	if want := "before\r\nafter\r\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("expected 2 GMCP messages, got %d", len(received))
+	}
+	if received[0].Package != "Char.Vitals" || string(received[0].Data) != "{\"hp\": 100, \"name\": \"x\xff\"}" {
+		t.Errorf("unexpected first message: %v", received[0])
+	}
+	if received[1].Package != "Core.Goodbye" || len(received[1].Data) != 0 {
+		t.Errorf("unexpected second message: %v", received[1])
+	}
+}
+
+func TestSendGMCP(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	client.SendGMCP("Core.Ping", nil)
+	client.SendGMCP("Comm.Say", []byte("\"\xff\""))
+
+	want := []byte{cmdIAC, cmdSB, optGMCP}
+	want = append(want, []byte("Core.Ping")...)
+	want = append(want, cmdIAC, cmdSE)
+	want = append(want, cmdIAC, cmdSB, optGMCP)
+	want = append(want, []byte("Comm.Say \"")...)
+	want = append(want, cmdIAC, cmdIAC, '"', cmdIAC, cmdSE)
+	expectBytes(t, server, want)
+}
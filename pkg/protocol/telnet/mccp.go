@@ -0,0 +1,213 @@
+package telnet
+
+import (
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// CompressionError reports a failure in a compressed (MCCP) stream. The
+// connection cannot recover from it and should be closed.
+type CompressionError struct {
+	Err error
+}
+
+func (e *CompressionError) Error() string {
+	return fmt.Sprintf("mccp: %v", e.Err)
+}
+
+func (e *CompressionError) Unwrap() error {
+	return e.Err
+}
+
+// CompressionStats describes the compression state of a connection
+type CompressionStats struct {
+	Active            bool  // Inbound data is currently compressed
+	Streams           int64 // Number of compressed streams started
+	CompressedBytes   int64 // Compressed bytes received from the server
+	DecompressedBytes int64 // Bytes produced by inflating those
+
+	OutboundActive          bool  // Outbound data is currently compressed (MCCP3)
+	OutboundBytes           int64 // Bytes written while compressing
+	OutboundCompressedBytes int64 // Compressed bytes sent for those
+}
+
+// Ratio returns the decompressed to compressed byte ratio, or 0 if nothing
+// has been compressed yet.
+func (s CompressionStats) Ratio() float64 {
+	if s.CompressedBytes == 0 {
+		return 0
+	}
+	return float64(s.DecompressedBytes) / float64(s.CompressedBytes)
+}
+
+type compressionCounters struct {
+	active       atomic.Bool
+	streams      atomic.Int64
+	compressed   atomic.Int64
+	decompressed atomic.Int64
+
+	outActive     atomic.Bool
+	outRaw        atomic.Int64
+	outCompressed atomic.Int64
+}
+
+// CompressionStats returns a snapshot of the connection's compression counters
+func (t *TelnetConnection) CompressionStats() CompressionStats {
+	return CompressionStats{
+		Active:            t.stats.active.Load(),
+		Streams:           t.stats.streams.Load(),
+		CompressedBytes:   t.stats.compressed.Load(),
+		DecompressedBytes: t.stats.decompressed.Load(),
+
+		OutboundActive:          t.stats.outActive.Load(),
+		OutboundBytes:           t.stats.outRaw.Load(),
+		OutboundCompressedBytes: t.stats.outCompressed.Load(),
+	}
+}
+
+// SetCompressOutput controls whether the connection offers MCCP3. Enabling it
+// takes effect the next time the server offers the option; disabling it ends
+// any active outbound stream and tells the server to stop expecting one.
+func (t *TelnetConnection) SetCompressOutput(enabled bool) {
+	t.mu.Lock()
+	var events []TelnetEvent
+	if !enabled && t.remoteEnabled(optMCCP3) {
+		events, _ = t.request(optMCCP3, false, false)
+	} else {
+		t.side(optMCCP3, false).supported = enabled
+	}
+	t.mu.Unlock()
+
+	t.dispatch("Option", events)
+}
+
+// send writes raw telnet data to the connection, compressing it while an
+// MCCP3 stream is active.
+func (t *TelnetConnection) send(data []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if t.deflater == nil {
+		_, err := t.conn.Write(data)
+		return err
+	}
+
+	if _, err := t.deflater.Write(data); err != nil {
+		return err
+	}
+	t.stats.outRaw.Add(int64(len(data)))
+	// Flush every write so commands aren't held back waiting for more input
+	return t.deflater.Flush()
+}
+
+// beginOutboundCompression announces and starts an MCCP3 stream
+func (t *TelnetConnection) beginOutboundCompression() {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if t.deflater != nil {
+		return
+	}
+	if _, err := t.conn.Write([]byte{cmdIAC, cmdSB, optMCCP3, cmdIAC, cmdSE}); err != nil {
+		return
+	}
+	t.deflater = zlib.NewWriter(&countingWriter{w: t.conn, n: &t.stats.outCompressed})
+	// Send the stream header straight away so the server can start inflating
+	t.deflater.Flush()
+	t.stats.outActive.Store(true)
+}
+
+// endOutboundCompression finishes the MCCP3 stream so following data is sent
+// uncompressed.
+func (t *TelnetConnection) endOutboundCompression() {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if t.deflater == nil {
+		return
+	}
+	t.deflater.Close()
+	t.deflater = nil
+	t.stats.outActive.Store(false)
+}
+
+// inflate reads the next chunk of an MCCP2 stream
+func (t *TelnetConnection) inflate(p []byte) (int, error) {
+	if t.inflater == nil {
+		z, err := zlib.NewReader(rawReader{t})
+		if connErr, ok := err.(connError); ok {
+			return 0, connErr.err
+		}
+		if err != nil {
+			t.endCompression()
+			t.raw.reset()
+			return 0, &CompressionError{Err: err}
+		}
+		t.inflater = z
+	}
+
+	n, err := t.inflater.Read(p)
+	t.stats.decompressed.Add(int64(n))
+	if err == io.EOF {
+		// The server ended the compressed stream, carry on with plain telnet.
+		// The inflater only consumes what it needs, so anything after the
+		// stream is still waiting in t.raw.
+		t.endCompression()
+		return n, nil
+	}
+	if connErr, ok := err.(connError); ok {
+		// Reading the connection failed, e.g. an idle timeout, rather
+		// than the stream itself
+		return n, connErr.err
+	}
+	if err != nil {
+		// The rest of the input can't be made sense of, so drop it rather
+		// than parse it as plain telnet
+		t.endCompression()
+		t.raw.reset()
+		return n, &CompressionError{Err: err}
+	}
+	return n, nil
+}
+
+// inboundCompressionEnabled reports whether the server may start an MCCP2
+// stream. A stray IAC SB MCCP2 IAC SE without it is ignored.
+func (t *TelnetConnection) inboundCompressionEnabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.remoteEnabled(optMCCP2)
+}
+
+// startCompression switches inbound data to an MCCP2 stream. The bytes
+// following IAC SE are left in t.raw for the inflater.
+func (t *TelnetConnection) startCompression() {
+	if t.inflated.buf == nil {
+		t.inflated = newRingBuffer(readBufferSize)
+	}
+	t.compressing = true
+	t.stats.active.Store(true)
+	t.stats.streams.Add(1)
+}
+
+func (t *TelnetConnection) endCompression() {
+	if t.inflater != nil {
+		t.inflater.Close()
+		t.inflater = nil
+	}
+	t.compressing = false
+	t.stats.active.Store(false)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
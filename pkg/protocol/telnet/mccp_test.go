@@ -0,0 +1,309 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// dialTestServer returns a telnet connection to a loopback listener along
+// with the server's end of the socket.
+func dialTestServer(t *testing.T) (*TelnetConnection, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal("Failed to dial:", err)
+	}
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("Failed to accept connection")
+	}
+
+	client := newTelnetConnection(conn, false)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// readAll reads from the connection until it is closed or fails
+func readAll(conn io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	buf := make([]byte, 64)
+	for {
+		n, err := conn.Read(buf)
+		out.Write(buf[:n])
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return out.Bytes(), err
+		}
+	}
+}
+
+// expectBytes reads exactly len(want) bytes from the server side. It is
+// safe to call from the goroutine playing the server.
+func expectBytes(t *testing.T, conn net.Conn, want []byte) bool {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Errorf("Failed to read %v from client: %v", want, err)
+		return false
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected client to send %v, got %v", want, got)
+		return false
+	}
+	return true
+}
+
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// enableMCCP2 has the server offer MCCP2 and waits for the client to
+// accept it
+func enableMCCP2(t *testing.T, server net.Conn) bool {
+	t.Helper()
+	server.Write([]byte{cmdIAC, cmdWILL, optMCCP2})
+	return expectBytes(t, server, []byte{cmdIAC, cmdDO, optMCCP2})
+}
+
+func TestMCCP2(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	go func() {
+		server.Write([]byte("plain\r\n"))
+		if !enableMCCP2(t, server) {
+			server.Close()
+			return
+		}
+
+		// Start the stream in the same segment as the subnegotiation
+		stream := []byte{cmdIAC, cmdSB, optMCCP2, cmdIAC, cmdSE}
+		stream = append(stream, compress([]byte("inflated\r\n"))...)
+		stream = append(stream, []byte("after\r\n")...)
+		server.Write(stream)
+		server.Close()
+	}()
+
+	got, err := readAll(client)
+	if err != nil {
+		t.Fatal("Read failed:", err)
+	}
+	if want := "plain\r\ninflated\r\nafter\r\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	stats := client.CompressionStats()
+	if stats.Active {
+		t.Error("expected compression to end with the stream")
+	}
+	if stats.Streams != 1 {
+		t.Errorf("expected 1 stream, got %d", stats.Streams)
+	}
+	if stats.DecompressedBytes != int64(len("inflated\r\n")) {
+		t.Errorf("expected %d decompressed bytes, got %d", len("inflated\r\n"), stats.DecompressedBytes)
+	}
+	if stats.CompressedBytes == 0 {
+		t.Error("expected compressed bytes to be counted")
+	}
+}
+
+func TestMCCP2CommandsInsideStream(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	go func() {
+		if !enableMCCP2(t, server) {
+			server.Close()
+			return
+		}
+		stream := []byte{cmdIAC, cmdSB, optMCCP2, cmdIAC, cmdSE}
+		inner := []byte("hp: 10")
+		inner = append(inner, cmdIAC, cmdGA, cmdIAC, cmdIAC)
+		inner = append(inner, []byte("\r\n")...)
+		stream = append(stream, compress(inner)...)
+		// Split the compressed stream across several writes
+		for len(stream) > 0 {
+			n := min(3, len(stream))
+			server.Write(stream[:n])
+			stream = stream[n:]
+			time.Sleep(time.Millisecond)
+		}
+		server.Close()
+	}()
+
+	got, err := readAll(client)
+	if err != nil {
+		t.Fatal("Read failed:", err)
+	}
+	if want := "hp: 10\xff\r\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestMCCP2CorruptStream(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	go func() {
+		if !enableMCCP2(t, server) {
+			server.Close()
+			return
+		}
+		stream := []byte{cmdIAC, cmdSB, optMCCP2, cmdIAC, cmdSE}
+		stream = append(stream, []byte("this is not zlib data")...)
+		server.Write(stream)
+	}()
+
+	_, err := readAll(client)
+	var compressionErr *CompressionError
+	if !errors.As(err, &compressionErr) {
+		t.Fatalf("expected a CompressionError, got %v", err)
+	}
+	if client.CompressionStats().Active {
+		t.Error("expected compression to be inactive after an error")
+	}
+}
+
+func TestMCCP2ReadTimeout(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	go func() {
+		if !enableMCCP2(t, server) {
+			server.Close()
+			return
+		}
+		// Start a stream, then go quiet in the middle of it
+		stream := []byte{cmdIAC, cmdSB, optMCCP2, cmdIAC, cmdSE}
+		data := compress([]byte("hello\r\n"))
+		server.Write(append(stream, data[:len(data)-4]...))
+	}()
+
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := readAll(client)
+	var compressionErr *CompressionError
+	if errors.As(err, &compressionErr) {
+		t.Fatalf("expected the timeout rather than a compression error, got %v", err)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the read deadline to pass, got %v", err)
+	}
+}
+
+func TestMCCP2NotNegotiated(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	go func() {
+		// A stray start of stream, without MCCP2 having been agreed
+		stream := []byte{cmdIAC, cmdSB, optMCCP2, cmdIAC, cmdSE}
+		stream = append(stream, []byte("plain\r\n")...)
+		server.Write(stream)
+		server.Close()
+	}()
+
+	got, err := readAll(client)
+	if err != nil {
+		t.Fatal("Read failed:", err)
+	}
+	if want := "plain\r\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if stats := client.CompressionStats(); stats.Streams != 0 {
+		t.Errorf("expected no compressed streams, got %d", stats.Streams)
+	}
+}
+
+func TestMCCP3(t *testing.T) {
+	client, server := dialTestServer(t)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdWILL, optMCCP3})
+	expectBytes(t, server, []byte{cmdIAC, cmdDO, optMCCP3, cmdIAC, cmdSB, optMCCP3, cmdIAC, cmdSE})
+
+	// A buffered reader lets the inflater stop exactly at the end of the stream
+	wire := bufio.NewReader(server)
+	inflater, err := zlib.NewReader(wire)
+	if err != nil {
+		t.Fatal("Failed to start inflating:", err)
+	}
+	client.Write([]byte("say \xffhi\n"))
+	got := make([]byte, len("say \xff\xffhi\n"))
+	if _, err := io.ReadFull(inflater, got); err != nil {
+		t.Fatal("Failed to inflate:", err)
+	}
+	if want := "say \xff\xffhi\n"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	stats := client.CompressionStats()
+	if !stats.OutboundActive {
+		t.Error("expected outbound compression to be active")
+	}
+	if stats.OutboundBytes != int64(len(got)) {
+		t.Errorf("expected %d outbound bytes, got %d", len(got), stats.OutboundBytes)
+	}
+
+	// Switching compression off ends the stream before falling back to plain telnet
+	client.SetCompressOutput(false)
+	if _, err := io.ReadAll(inflater); err != nil {
+		t.Fatal("Expected the compressed stream to end cleanly:", err)
+	}
+	rest := make([]byte, 3)
+	if _, err := io.ReadFull(wire, rest); err != nil {
+		t.Fatal("Failed to read after stream end:", err)
+	}
+	if !bytes.Equal(rest, []byte{cmdIAC, cmdDONT, optMCCP3}) {
+		t.Errorf("expected IAC DONT MCCP3, got %v", rest)
+	}
+
+	client.Write([]byte("look\n"))
+	line := make([]byte, 5)
+	if _, err := io.ReadFull(wire, line); err != nil {
+		t.Fatal("Failed to read plain data:", err)
+	}
+	if string(line) != "look\n" {
+		t.Errorf("expected plain %q, got %q", "look\n", line)
+	}
+	if client.CompressionStats().OutboundActive {
+		t.Error("expected outbound compression to be inactive")
+	}
+}
+
+func TestMCCP3Refused(t *testing.T) {
+	client, server := dialTestServer(t)
+	client.SetCompressOutput(false)
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdWILL, optMCCP3})
+	expectBytes(t, server, []byte{cmdIAC, cmdDONT, optMCCP3})
+}
@@ -0,0 +1,164 @@
+package telnet
+
+import (
+	"fmt"
+)
+
+// MSDP subnegotiation bytes
+const (
+	msdpVAR         = 1
+	msdpVAL         = 2
+	msdpTABLE_OPEN  = 3
+	msdpTABLE_CLOSE = 4
+	msdpARRAY_OPEN  = 5
+	msdpARRAY_CLOSE = 6
+)
+
+// MSDPEvent is a variable reported by the server over MSDP. Value is a
+// string, a []interface{} for arrays or a map[string]interface{} for tables.
+type MSDPEvent struct {
+	Variable string
+	Value    interface{}
+}
+
+func (e MSDPEvent) String() string {
+	return fmt.Sprintf("MSDP %s=%v", e.Variable, e.Value)
+}
+
+// msdpParser decodes an MSDP subnegotiation payload
+type msdpParser struct {
+	data []byte
+	pos  int
+}
+
+// parseMSDP decodes every variable in an MSDP payload
+func parseMSDP(data []byte) []MSDPEvent {
+	p := &msdpParser{data: data}
+
+	var events []MSDPEvent
+	for p.skipTo(msdpVAR) {
+		p.pos++
+		name := p.text()
+		value := p.values()
+		events = append(events, MSDPEvent{Variable: name, Value: value})
+	}
+	return events
+}
+
+// skipTo advances to the next occurrence of b, reporting whether it was found
+func (p *msdpParser) skipTo(b byte) bool {
+	for p.pos < len(p.data) {
+		if p.data[p.pos] == b {
+			return true
+		}
+		p.pos++
+	}
+	return false
+}
+
+func (p *msdpParser) peek() byte {
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+// text reads a plain string up to the next MSDP control byte
+func (p *msdpParser) text() string {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] > msdpARRAY_CLOSE {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// values reads the VAL entries following a variable name, stopping at the
+// next VAR or at end. Several values for one variable are returned as an
+// array.
+func (p *msdpParser) values() interface{} {
+	var values []interface{}
+	for p.pos < len(p.data) && p.peek() == msdpVAL {
+		p.pos++
+		values = append(values, p.value())
+	}
+	switch len(values) {
+	case 0:
+		return ""
+	case 1:
+		return values[0]
+	default:
+		return values
+	}
+}
+
+// value reads a single value: a string, a table or an array
+func (p *msdpParser) value() interface{} {
+	switch p.peek() {
+	case msdpTABLE_OPEN:
+		p.pos++
+		table := make(map[string]interface{})
+		for p.pos < len(p.data) {
+			switch p.peek() {
+			case msdpTABLE_CLOSE:
+				p.pos++
+				return table
+			case msdpVAR:
+				p.pos++
+				name := p.text()
+				table[name] = p.values()
+			default:
+				// Skip anything unexpected rather than failing the whole message
+				p.pos++
+			}
+		}
+		return table
+	case msdpARRAY_OPEN:
+		p.pos++
+		array := []interface{}{}
+		for p.pos < len(p.data) {
+			switch p.peek() {
+			case msdpARRAY_CLOSE:
+				p.pos++
+				return array
+			case msdpVAL:
+				p.pos++
+				array = append(array, p.value())
+			default:
+				p.pos++
+			}
+		}
+		return array
+	default:
+		return p.text()
+	}
+}
+
+// SendMSDP sends a variable with its values, e.g. SendMSDP("REPORT",
+// "HEALTH", "MANA"). Requests made before the server enables MSDP are held
+// and sent once it does.
+func (t *TelnetConnection) SendMSDP(variable string, values ...string) error {
+	msg := []byte{cmdIAC, cmdSB, optMSDP, msdpVAR}
+	msg = appendEscaped(msg, []byte(variable))
+	for _, value := range values {
+		msg = append(msg, msdpVAL)
+		msg = appendEscaped(msg, []byte(value))
+	}
+	msg = append(msg, cmdIAC, cmdSE)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.remoteEnabled(optMSDP) {
+		t.msdpPending = append(t.msdpPending, msg)
+		return nil
+	}
+	return t.send(msg)
+}
+
+// flushMSDP sends requests queued before MSDP was enabled
+func (t *TelnetConnection) flushMSDP() {
+	for _, msg := range t.msdpPending {
+		t.send(msg)
+	}
+	t.msdpPending = nil
+}
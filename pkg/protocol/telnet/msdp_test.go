@@ -0,0 +1,88 @@
+package telnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMSDP(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []MSDPEvent
+	}{
+		{
+			name: "single variable",
+			data: []byte("\x01HEALTH\x02100"),
+			want: []MSDPEvent{{Variable: "HEALTH", Value: "100"}},
+		},
+		{
+			name: "several variables",
+			data: []byte("\x01HEALTH\x02100\x01MANA\x0250"),
+			want: []MSDPEvent{
+				{Variable: "HEALTH", Value: "100"},
+				{Variable: "MANA", Value: "50"},
+			},
+		},
+		{
+			name: "repeated values",
+			data: []byte("\x01REPORTABLE\x02HEALTH\x02MANA"),
+			want: []MSDPEvent{{Variable: "REPORTABLE", Value: []interface{}{"HEALTH", "MANA"}}},
+		},
+		{
+			name: "array",
+			data: []byte("\x01COMMANDS\x02\x05\x02LIST\x02REPORT\x06"),
+			want: []MSDPEvent{{Variable: "COMMANDS", Value: []interface{}{"LIST", "REPORT"}}},
+		},
+		{
+			name: "nested table",
+			data: []byte("\x01ROOM\x02\x03\x01VNUM\x026008\x01EXITS\x02\x03\x01n\x026011\x04\x01TAGS\x02\x05\x02dark\x06\x04"),
+			want: []MSDPEvent{{Variable: "ROOM", Value: map[string]interface{}{
+				"VNUM":  "6008",
+				"EXITS": map[string]interface{}{"n": "6011"},
+				"TAGS":  []interface{}{"dark"},
+			}}},
+		},
+		{
+			name: "empty value",
+			data: []byte("\x01AFFECTS\x02"),
+			want: []MSDPEvent{{Variable: "AFFECTS", Value: ""}},
+		},
+		{
+			name: "unterminated table",
+			data: []byte("\x01ROOM\x02\x03\x01NAME\x02Hall"),
+			want: []MSDPEvent{{Variable: "ROOM", Value: map[string]interface{}{"NAME": "Hall"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseMSDP(tt.data)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSendMSDPQueuedUntilEnabled(t *testing.T) {
+	client, server := dialTestServer(t)
+	go readAll(client)
+
+	client.SendMSDP("REPORT", "HEALTH", "MANA")
+	server.Write([]byte{cmdIAC, cmdWILL, optMSDP})
+
+	want := []byte{cmdIAC, cmdDO, optMSDP}
+	want = append(want, cmdIAC, cmdSB, optMSDP)
+	want = append(want, []byte("\x01REPORT\x02HEALTH\x02MANA")...)
+	want = append(want, cmdIAC, cmdSE)
+	if !expectBytes(t, server, want) {
+		return
+	}
+
+	client.SendMSDP("SEND", "ROOM")
+	want = []byte{cmdIAC, cmdSB, optMSDP}
+	want = append(want, []byte("\x01SEND\x02ROOM")...)
+	want = append(want, cmdIAC, cmdSE)
+	expectBytes(t, server, want)
+}
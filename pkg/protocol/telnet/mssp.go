@@ -0,0 +1,73 @@
+package telnet
+
+import (
+	"fmt"
+)
+
+// MSSP subnegotiation bytes
+const (
+	msspVAR = 1
+	msspVAL = 2
+)
+
+// MSSPEvent carries the server status variables sent over MSSP. A variable
+// may have several values, e.g. one per listening port.
+type MSSPEvent struct {
+	Variables map[string][]string
+}
+
+func (e MSSPEvent) String() string {
+	return fmt.Sprintf("MSSP %v", e.Variables)
+}
+
+// parseMSSP decodes the VAR/VAL pairs of an MSSP payload
+func parseMSSP(data []byte) MSSPEvent {
+	vars := make(map[string][]string)
+
+	var name []byte
+	var value []byte
+	inValue := false
+	flush := func() {
+		if inValue && len(name) > 0 {
+			vars[string(name)] = append(vars[string(name)], string(value))
+		}
+	}
+
+	for _, b := range data {
+		switch b {
+		case msspVAR:
+			flush()
+			name = name[:0]
+			inValue = false
+		case msspVAL:
+			flush()
+			value = value[:0]
+			inValue = true
+		default:
+			if inValue {
+				value = append(value, b)
+			} else {
+				name = append(name, b)
+			}
+		}
+	}
+	flush()
+
+	return MSSPEvent{Variables: vars}
+}
+
+// MSSP returns the server status variables received so far, or nil if the
+// server hasn't sent any.
+func (t *TelnetConnection) MSSP() map[string][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.mssp == nil {
+		return nil
+	}
+	vars := make(map[string][]string, len(t.mssp))
+	for name, values := range t.mssp {
+		vars[name] = append([]string(nil), values...)
+	}
+	return vars
+}
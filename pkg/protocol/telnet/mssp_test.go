@@ -0,0 +1,50 @@
+package telnet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMSSP(t *testing.T) {
+	data := []byte("\x01NAME\x02Test MUD\x01PORT\x024000\x025000\x01CODEBASE\x02\x01EMPTY")
+	want := map[string][]string{
+		"NAME":     {"Test MUD"},
+		"PORT":     {"4000", "5000"},
+		"CODEBASE": {""},
+	}
+
+	got := parseMSSP(data).Variables
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMSSPStoredOnConnection(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	if client.MSSP() != nil {
+		t.Fatal("expected no MSSP data before the server sends any")
+	}
+
+	go func() {
+		server.Write([]byte{cmdIAC, cmdWILL, optMSSP})
+		if !expectBytes(t, server, []byte{cmdIAC, cmdDO, optMSSP}) {
+			server.Close()
+			return
+		}
+		msg := []byte{cmdIAC, cmdSB, optMSSP}
+		msg = append(msg, []byte("\x01NAME\x02Test MUD\x01PLAYERS\x0212")...)
+		msg = append(msg, cmdIAC, cmdSE)
+		server.Write(msg)
+		server.Close()
+	}()
+
+	if _, err := readAll(client); err != nil {
+		t.Fatal("Read failed:", err)
+	}
+
+	want := map[string][]string{"NAME": {"Test MUD"}, "PLAYERS": {"12"}}
+	if got := client.MSSP(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,37 @@
+package telnet
+
+// SetWindowSize sets the window size reported to the server with NAWS. The
+// size is sent immediately if NAWS is active, otherwise once the server asks
+// for it.
+func (t *TelnetConnection) SetWindowSize(cols, rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cols, t.rows = clampSize(cols), clampSize(rows)
+	if t.localEnabled(optWINDOW_SIZE) {
+		t.sendWindowSize()
+	}
+}
+
+// WindowSize returns the window size reported to the server
+func (t *TelnetConnection) WindowSize() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cols, t.rows
+}
+
+func (t *TelnetConnection) sendWindowSize() {
+	size := []byte{
+		byte(t.cols >> 8), byte(t.cols),
+		byte(t.rows >> 8), byte(t.rows),
+	}
+	msg := []byte{cmdIAC, cmdSB, optWINDOW_SIZE}
+	msg = appendEscaped(msg, size)
+	msg = append(msg, cmdIAC, cmdSE)
+	t.send(msg)
+}
+
+// clampSize limits a dimension to what NAWS can carry
+func clampSize(n int) int {
+	return max(0, min(n, 0xffff))
+}
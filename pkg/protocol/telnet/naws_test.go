@@ -0,0 +1,31 @@
+package telnet
+
+import (
+	"testing"
+)
+
+func TestNAWS(t *testing.T) {
+	client, server := dialTestServer(t)
+	client.SetWindowSize(120, 40)
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdDO, optWINDOW_SIZE})
+	want := []byte{
+		cmdIAC, cmdWILL, optWINDOW_SIZE,
+		cmdIAC, cmdSB, optWINDOW_SIZE, 0, 120, 0, 40, cmdIAC, cmdSE,
+	}
+	if !expectBytes(t, server, want) {
+		return
+	}
+
+	// Resizing once NAWS is active reports the new size, escaping IAC
+	client.SetWindowSize(255, 300)
+	want = []byte{
+		cmdIAC, cmdSB, optWINDOW_SIZE, 0, cmdIAC, cmdIAC, 1, 44, cmdIAC, cmdSE,
+	}
+	expectBytes(t, server, want)
+
+	if cols, rows := client.WindowSize(); cols != 255 || rows != 300 {
+		t.Errorf("expected 255x300, got %dx%d", cols, rows)
+	}
+}
@@ -0,0 +1,336 @@
+package telnet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// QState is the negotiation state of one side of a telnet option, as in the
+// Q method of RFC 1143
+type QState int
+
+const (
+	QNo      QState = iota // Disabled
+	QYes                   // Enabled
+	QWantNo                // Asked to disable, waiting for the reply
+	QWantYes               // Asked to enable, waiting for the reply
+)
+
+func (s QState) String() string {
+	switch s {
+	case QNo:
+		return "NO"
+	case QYes:
+		return "YES"
+	case QWantNo:
+		return "WANTNO"
+	case QWantYes:
+		return "WANTYES"
+	}
+	return fmt.Sprintf("QState(%d)", int(s))
+}
+
+// Errors returned when requesting an option change
+var (
+	ErrOptionEnabled  = errors.New("option already enabled")
+	ErrOptionDisabled = errors.New("option already disabled")
+	ErrNegotiating    = errors.New("option already being negotiated")
+	ErrQueued         = errors.New("option change already queued")
+)
+
+// OptionEvent reports an option being enabled or disabled on one side of the
+// connection
+type OptionEvent struct {
+	Option  byte
+	Local   bool // Our side (WILL/WONT) rather than the server's (DO/DONT)
+	Enabled bool
+}
+
+// qSide is one side of an option. opposite is the queue bit: once the
+// pending request is answered, ask for the opposite.
+type qSide struct {
+	state     QState
+	opposite  bool
+	supported bool // Agree when the other end asks to enable the option
+}
+
+// option tracks both sides of a telnet option. local is our side, enabled
+// with WILL, and remote is the server's, enabled with DO.
+type option struct {
+	local  qSide
+	remote qSide
+}
+
+// qAction is the reply chosen by the state machine
+type qAction int
+
+const (
+	qNone        qAction = iota
+	qSendEnable          // Send DO for the remote side, WILL for the local side
+	qSendDisable         // Send DONT for the remote side, WONT for the local side
+)
+
+// receive handles the other end asking to enable (WILL/DO) or disable
+// (WONT/DONT) the option
+func (s *qSide) receive(enable bool) qAction {
+	if enable {
+		switch s.state {
+		case QNo:
+			if !s.supported {
+				return qSendDisable
+			}
+			s.state = QYes
+			return qSendEnable
+		case QWantNo:
+			// Our disable request was answered with an enable
+			if s.opposite {
+				s.state = QYes
+				s.opposite = false
+			} else {
+				s.state = QNo
+			}
+		case QWantYes:
+			if s.opposite {
+				s.state = QWantNo
+				s.opposite = false
+				return qSendDisable
+			}
+			s.state = QYes
+		}
+		return qNone
+	}
+
+	switch s.state {
+	case QYes:
+		s.state = QNo
+		return qSendDisable
+	case QWantNo:
+		if s.opposite {
+			s.state = QWantYes
+			s.opposite = false
+			return qSendEnable
+		}
+		s.state = QNo
+	case QWantYes:
+		s.state = QNo
+		s.opposite = false
+	}
+	return qNone
+}
+
+// request asks the other end to enable or disable the option
+func (s *qSide) request(enable bool) (qAction, error) {
+	want, other := QWantYes, QWantNo
+	if !enable {
+		want, other = QWantNo, QWantYes
+	}
+
+	switch s.state {
+	case QNo:
+		if !enable {
+			return qNone, ErrOptionDisabled
+		}
+		s.state = want
+		return qSendEnable, nil
+	case QYes:
+		if enable {
+			return qNone, ErrOptionEnabled
+		}
+		s.state = want
+		return qSendDisable, nil
+	case want:
+		if !s.opposite {
+			return qNone, ErrNegotiating
+		}
+		s.opposite = false
+	case other:
+		if s.opposite {
+			return qNone, ErrQueued
+		}
+		s.opposite = true
+	}
+	return qNone, nil
+}
+
+// EnableLocal offers to enable an option on our side (WILL) and agrees to
+// the server asking for it from now on
+func (t *TelnetConnection) EnableLocal(opt byte) error {
+	return t.requestOption(opt, true, true)
+}
+
+// DisableLocal disables an option on our side (WONT) and refuses the server
+// asking for it from now on
+func (t *TelnetConnection) DisableLocal(opt byte) error {
+	return t.requestOption(opt, true, false)
+}
+
+// EnableRemote asks the server to enable an option (DO) and agrees to the
+// server offering it from now on
+func (t *TelnetConnection) EnableRemote(opt byte) error {
+	return t.requestOption(opt, false, true)
+}
+
+// DisableRemote asks the server to disable an option (DONT) and refuses the
+// server offering it from now on
+func (t *TelnetConnection) DisableRemote(opt byte) error {
+	return t.requestOption(opt, false, false)
+}
+
+// OptionState returns the negotiation state of both sides of an option
+func (t *TelnetConnection) OptionState(opt byte) (local, remote QState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if o, ok := t.options[opt]; ok {
+		return o.local.state, o.remote.state
+	}
+	return QNo, QNo
+}
+
+func (t *TelnetConnection) requestOption(opt byte, local, enable bool) error {
+	t.mu.Lock()
+	events, err := t.request(opt, local, enable)
+	t.mu.Unlock()
+
+	t.dispatch("Option", events)
+	return err
+}
+
+// request changes whether an option is supported and asks the other end to
+// switch it. Called with t.mu held.
+func (t *TelnetConnection) request(opt byte, local, enable bool) ([]TelnetEvent, error) {
+	side := t.side(opt, local)
+	side.supported = enable
+
+	wasEnabled := side.state == QYes
+	action, err := side.request(enable)
+	return t.negotiate(opt, local, wasEnabled, action), err
+}
+
+// receiveOption handles WILL/WONT/DO/DONT from the server. Called with t.mu
+// held.
+func (t *TelnetConnection) receiveOption(opt byte, local, enable bool) []TelnetEvent {
+	side := t.side(opt, local)
+	wasEnabled := side.state == QYes
+	action := side.receive(enable)
+	return t.negotiate(opt, local, wasEnabled, action)
+}
+
+// negotiate sends the reply chosen by the state machine and runs the side
+// effects of the option being switched on or off. Called with t.mu held.
+func (t *TelnetConnection) negotiate(opt byte, local, wasEnabled bool, action qAction) []TelnetEvent {
+	enabled := t.side(opt, local).state == QYes
+	if enabled == wasEnabled {
+		t.sendAction(opt, local, action)
+		return nil
+	}
+
+	events := []TelnetEvent{OptionEvent{Option: opt, Local: local, Enabled: enabled}}
+	if enabled {
+		// Agree first so anything the option sends follows the reply
+		t.sendAction(opt, local, action)
+		return append(events, t.optionChanged(opt, local, true)...)
+	}
+	// Wind the option down before telling the other end it's gone
+	events = append(events, t.optionChanged(opt, local, false)...)
+	t.sendAction(opt, local, action)
+	return events
+}
+
+func (t *TelnetConnection) sendAction(opt byte, local bool, action qAction) {
+	var cmd byte
+	switch {
+	case action == qSendEnable && local:
+		cmd = cmdWILL
+	case action == qSendDisable && local:
+		cmd = cmdWONT
+	case action == qSendEnable:
+		cmd = cmdDO
+	case action == qSendDisable:
+		cmd = cmdDONT
+	default:
+		return
+	}
+	t.send([]byte{cmdIAC, cmd, opt})
+}
+
+// side returns one side of an option, adding the option if it isn't
+// tracked yet
+func (t *TelnetConnection) side(opt byte, local bool) *qSide {
+	o, ok := t.options[opt]
+	if !ok {
+		o = &option{}
+		t.options[opt] = o
+	}
+	if local {
+		return &o.local
+	}
+	return &o.remote
+}
+
+// localEnabled reports whether an option is enabled on our side. Called with
+// t.mu held.
+func (t *TelnetConnection) localEnabled(opt byte) bool {
+	o, ok := t.options[opt]
+	return ok && o.local.state == QYes
+}
+
+// remoteEnabled reports whether the server has enabled an option. Called
+// with t.mu held.
+func (t *TelnetConnection) remoteEnabled(opt byte) bool {
+	o, ok := t.options[opt]
+	return ok && o.remote.state == QYes
+}
+
+// optionChanged runs the side effects of an option being enabled or
+// disabled. Called with t.mu held.
+func (t *TelnetConnection) optionChanged(opt byte, local, enabled bool) []TelnetEvent {
+	var events []TelnetEvent
+	switch opt {
+	case optECHO:
+		if !local {
+			events = append(events, EchoEvent{Enabled: enabled})
+		}
+	case optMCCP3:
+		if local {
+			break
+		}
+		if enabled {
+			t.beginOutboundCompression()
+		} else {
+			t.endOutboundCompression()
+		}
+	case optGMCP:
+		if enabled && !local {
+			t.sendGMCPHello()
+		}
+	case optMSDP:
+		if enabled && !local {
+			t.flushMSDP()
+		}
+	case optCHARSET:
+		if enabled && !local {
+			t.sendCharsetRequest()
+		}
+	case optMXP:
+		events = append(events, MXPEvent{Enabled: enabled})
+	case optMSP:
+		if !local {
+			events = append(events, MSPEvent{Enabled: enabled})
+		}
+	case optWINDOW_SIZE:
+		if enabled && local {
+			t.sendWindowSize()
+		}
+	case optTERMINAL_TYPE:
+		if enabled && local {
+			t.ttypeIndex = 0
+		}
+	case optNEW_ENVIRON:
+		if enabled && local {
+			// Values are only sent as INFO once the server has asked
+			t.environSent = nil
+		}
+	}
+	return events
+}
@@ -0,0 +1,198 @@
+package telnet
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestQSideReceive(t *testing.T) {
+	tests := []struct {
+		name      string
+		side      qSide
+		enable    bool
+		want      QState
+		wantQueue bool
+		action    qAction
+	}{
+		{"enable supported", qSide{state: QNo, supported: true}, true, QYes, false, qSendEnable},
+		{"enable unsupported", qSide{state: QNo}, true, QNo, false, qSendDisable},
+		{"enable while enabled", qSide{state: QYes}, true, QYes, false, qNone},
+		{"enable answers disable request", qSide{state: QWantNo}, true, QNo, false, qNone},
+		{"enable answers queued disable request", qSide{state: QWantNo, opposite: true}, true, QYes, false, qNone},
+		{"enable accepted", qSide{state: QWantYes}, true, QYes, false, qNone},
+		{"enable accepted with disable queued", qSide{state: QWantYes, opposite: true}, true, QWantNo, false, qSendDisable},
+		{"disable while disabled", qSide{state: QNo}, false, QNo, false, qNone},
+		{"disable while enabled", qSide{state: QYes}, false, QNo, false, qSendDisable},
+		{"disable accepted", qSide{state: QWantNo}, false, QNo, false, qNone},
+		{"disable accepted with enable queued", qSide{state: QWantNo, opposite: true}, false, QWantYes, false, qSendEnable},
+		{"enable refused", qSide{state: QWantYes}, false, QNo, false, qNone},
+		{"enable refused with disable queued", qSide{state: QWantYes, opposite: true}, false, QNo, false, qNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			side := tt.side
+			action := side.receive(tt.enable)
+			if side.state != tt.want || side.opposite != tt.wantQueue {
+				t.Errorf("expected %v (queued %v), got %v (queued %v)", tt.want, tt.wantQueue, side.state, side.opposite)
+			}
+			if action != tt.action {
+				t.Errorf("expected action %v, got %v", tt.action, action)
+			}
+		})
+	}
+}
+
+func TestQSideRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		side      qSide
+		enable    bool
+		want      QState
+		wantQueue bool
+		action    qAction
+		err       error
+	}{
+		{"enable", qSide{state: QNo}, true, QWantYes, false, qSendEnable, nil},
+		{"enable while enabled", qSide{state: QYes}, true, QYes, false, qNone, ErrOptionEnabled},
+		{"enable while disabling", qSide{state: QWantNo}, true, QWantNo, true, qNone, nil},
+		{"enable already queued", qSide{state: QWantNo, opposite: true}, true, QWantNo, true, qNone, ErrQueued},
+		{"enable while enabling", qSide{state: QWantYes}, true, QWantYes, false, qNone, ErrNegotiating},
+		{"enable cancels queued disable", qSide{state: QWantYes, opposite: true}, true, QWantYes, false, qNone, nil},
+		{"disable", qSide{state: QYes}, false, QWantNo, false, qSendDisable, nil},
+		{"disable while disabled", qSide{state: QNo}, false, QNo, false, qNone, ErrOptionDisabled},
+		{"disable while enabling", qSide{state: QWantYes}, false, QWantYes, true, qNone, nil},
+		{"disable already queued", qSide{state: QWantYes, opposite: true}, false, QWantYes, true, qNone, ErrQueued},
+		{"disable while disabling", qSide{state: QWantNo}, false, QWantNo, false, qNone, ErrNegotiating},
+		{"disable cancels queued enable", qSide{state: QWantNo, opposite: true}, false, QWantNo, false, qNone, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			side := tt.side
+			action, err := side.request(tt.enable)
+			if err != tt.err {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+			if side.state != tt.want || side.opposite != tt.wantQueue {
+				t.Errorf("expected %v (queued %v), got %v (queued %v)", tt.want, tt.wantQueue, side.state, side.opposite)
+			}
+			if action != tt.action {
+				t.Errorf("expected action %v, got %v", tt.action, action)
+			}
+		})
+	}
+}
+
+func TestNegotiationDoesNotLoop(t *testing.T) {
+	client, server := dialTestServer(t)
+	go readAll(client)
+
+	// Refusals of options that were never enabled get no reply, and repeated
+	// offers of an enabled option aren't acknowledged again. Only the
+	// answers to the first offers and the unsupported option come back.
+	server.Write([]byte{
+		cmdIAC, cmdWONT, optGMCP,
+		cmdIAC, cmdDONT, optWINDOW_SIZE,
+		cmdIAC, cmdWILL, optGMCP,
+		cmdIAC, cmdWILL, optGMCP,
+		cmdIAC, cmdDO, optTERMINAL_TYPE,
+		cmdIAC, cmdDO, optTERMINAL_TYPE,
+		cmdIAC, cmdWILL, 99,
+	})
+	want := []byte{cmdIAC, cmdDO, optGMCP}
+	if !expectBytes(t, server, want) {
+		return
+	}
+
+	// Skip the Core.Hello and Core.Supports.Set messages
+	hello := []byte{cmdIAC, cmdSB, optGMCP}
+	hello = append(hello, "Core.Hello {\"client\":\"Runes\",\"version\":\"1.0.0\"}"...)
+	hello = append(hello, cmdIAC, cmdSE, cmdIAC, cmdSB, optGMCP)
+	hello = append(hello, "Core.Supports.Set []"...)
+	hello = append(hello, cmdIAC, cmdSE)
+	if !expectBytes(t, server, hello) {
+		return
+	}
+	expectBytes(t, server, []byte{cmdIAC, cmdWILL, optTERMINAL_TYPE, cmdIAC, cmdDONT, 99})
+}
+
+func TestRequestOption(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	var mu sync.Mutex
+	var events []OptionEvent
+	client.SetEventHandler(func(e TelnetEvent) {
+		if e, ok := e.(OptionEvent); ok {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		}
+	})
+	go readAll(client)
+
+	if err := client.EnableRemote(optECHO); err != nil {
+		t.Fatal("EnableRemote failed:", err)
+	}
+	if err := client.EnableRemote(optECHO); err != ErrNegotiating {
+		t.Errorf("expected %v, got %v", ErrNegotiating, err)
+	}
+	if _, remote := client.OptionState(optECHO); remote != QWantYes {
+		t.Errorf("expected %v, got %v", QWantYes, remote)
+	}
+	if !expectBytes(t, server, []byte{cmdIAC, cmdDO, optECHO}) {
+		return
+	}
+
+	// Agreeing to our request gets no further reply
+	server.Write([]byte{cmdIAC, cmdWILL, optECHO})
+	if err := client.EnableLocal(optSTATUS); err != nil {
+		t.Fatal("EnableLocal failed:", err)
+	}
+	if !expectBytes(t, server, []byte{cmdIAC, cmdWILL, optSTATUS}) {
+		return
+	}
+
+	server.Write([]byte{cmdIAC, cmdDO, optSTATUS, cmdIAC, cmdDONT, optSTATUS})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdWONT, optSTATUS}) {
+		return
+	}
+
+	client.DisableRemote(optECHO)
+	if !expectBytes(t, server, []byte{cmdIAC, cmdDONT, optECHO}) {
+		return
+	}
+	server.Write([]byte{cmdIAC, cmdWONT, optECHO})
+
+	// Offers of an option we asked to disable are refused
+	server.Write([]byte{cmdIAC, cmdWILL, optECHO})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdDONT, optECHO}) {
+		return
+	}
+	if local, remote := client.OptionState(optECHO); local != QNo || remote != QNo {
+		t.Errorf("expected NO/NO, got %v/%v", local, remote)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []OptionEvent{
+		{Option: optECHO, Enabled: true},
+		{Option: optSTATUS, Local: true, Enabled: true},
+		{Option: optSTATUS, Local: true, Enabled: false},
+		{Option: optECHO, Enabled: false},
+	}
+	// Events from the server and from our requests are reported on different
+	// goroutines, so only the set of events is checked
+	if len(events) != len(want) {
+		t.Fatalf("expected events %+v, got %+v", want, events)
+	}
+	for _, e := range want {
+		found := false
+		for _, got := range events {
+			found = found || got == e
+		}
+		if !found {
+			t.Errorf("missing event %+v in %+v", e, events)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package telnet
+
+import (
+	"fmt"
+	"io"
+)
+
+// readBufferSize is the size of the buffers telnet data is parsed from
+const readBufferSize = 4096
+
+// DefaultMaxSubnegotiation is the largest subnegotiation payload accepted
+// unless changed with SetMaxSubnegotiation. Longer payloads are dropped.
+const DefaultMaxSubnegotiation = 1 << 20
+
+// ringBuffer is a fixed size circular byte buffer. The parser reads bytes
+// straight out of it, so reading doesn't copy or allocate.
+type ringBuffer struct {
+	buf []byte
+	r   int // Index of the next unread byte
+	n   int // Number of unread bytes
+}
+
+func newRingBuffer(size int) ringBuffer {
+	return ringBuffer{buf: make([]byte, size)}
+}
+
+// Len returns the number of unread bytes
+func (b *ringBuffer) Len() int {
+	return b.n
+}
+
+// peek returns the next unread byte. The buffer must not be empty.
+func (b *ringBuffer) peek() byte {
+	return b.buf[b.r]
+}
+
+// skip consumes the next unread byte
+func (b *ringBuffer) skip() {
+	b.r++
+	if b.r == len(b.buf) {
+		b.r = 0
+	}
+	b.n--
+}
+
+// reset discards the unread bytes
+func (b *ringBuffer) reset() {
+	b.r, b.n = 0, 0
+}
+
+// read copies unread bytes into p
+func (b *ringBuffer) read(p []byte) int {
+	n := 0
+	for n < len(p) && b.n > 0 {
+		end := b.r + b.n
+		if end > len(b.buf) {
+			end = len(b.buf)
+		}
+		c := copy(p[n:], b.buf[b.r:end])
+		n += c
+		b.r = (b.r + c) % len(b.buf)
+		b.n -= c
+	}
+	return n
+}
+
+// fill calls read with the free space following the unread bytes
+func (b *ringBuffer) fill(read func([]byte) (int, error)) (int, error) {
+	if b.n == 0 {
+		// Start over so the whole buffer is available
+		b.r = 0
+	}
+	w := b.r + b.n
+	var free []byte
+	switch {
+	case b.n == len(b.buf):
+		return 0, nil
+	case w < len(b.buf):
+		free = b.buf[w:]
+	default:
+		free = b.buf[w-len(b.buf) : b.r]
+	}
+	n, err := read(free)
+	b.n += n
+	return n, err
+}
+
+// parseState tracks where the parser is within the telnet stream. It is kept
+// on the connection so sequences split across reads are handled correctly.
+type parseState int
+
+const (
+	stateData      parseState = iota // Plain data
+	stateIAC                         // Saw IAC
+	stateNegotiate                   // Saw IAC WILL/WONT/DO/DONT, waiting for the option
+	stateSB                          // Saw IAC SB, waiting for the option
+	stateSBData                      // Inside a subnegotiation payload
+	stateSBIAC                       // Saw IAC inside a subnegotiation payload
+)
+
+// SetMaxSubnegotiation sets the largest subnegotiation payload accepted.
+// Longer payloads are dropped. It must be called before reading starts.
+func (t *TelnetConnection) SetMaxSubnegotiation(size int) {
+	t.maxSubneg = size
+}
+
+// Read returns data from the server with telnet commands removed and text
+// decoded to UTF-8.
+func (t *TelnetConnection) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		if t.promptPending {
+			// The data before the prompt marker has been returned, so the
+			// prompt can be reported now
+			t.promptPending = false
+			t.dispatch("Prompt", []TelnetEvent{PromptEvent{}})
+		}
+		if len(t.held) > 0 {
+			held := t.held
+			t.held = nil
+			t.dispatch(t.heldKind, held)
+		}
+		if len(t.spill) > 0 {
+			n := copy(p, t.spill)
+			t.spill = t.spill[n:]
+			return n, nil
+		}
+
+		src := t.source()
+		if src.Len() > 0 {
+			if n := t.process(src, p); n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		// Errors are returned once the data read with them has been parsed
+		if err := t.readErr; err != nil {
+			t.readErr = nil
+			return 0, err
+		}
+		t.readErr = t.fill()
+	}
+}
+
+// source returns the buffer to parse: the inflated stream while MCCP2 is
+// active or any of it is left over, and the raw connection data otherwise.
+func (t *TelnetConnection) source() *ringBuffer {
+	if t.compressing || t.inflated.Len() > 0 {
+		return &t.inflated
+	}
+	return &t.raw
+}
+
+// fill reads more data into the current source
+func (t *TelnetConnection) fill() error {
+	if t.compressing {
+		_, err := t.inflated.fill(t.inflate)
+		return err
+	}
+	_, err := t.raw.fill(t.readConn)
+	return err
+}
+
+// process parses telnet data from src, handling any commands it contains,
+// and writes the plain data to p. It stops when p is full, at a prompt
+// marker or a change of MXP or MSP following data, or when a compressed
+// stream starts, leaving the rest of src for the next call.
+func (t *TelnetConnection) process(src *ringBuffer, p []byte) int {
+	cm := t.charmap()
+	out := 0
+	for ; src.Len() > 0; src.skip() {
+		b := src.peek()
+
+		switch t.state {
+		case stateData:
+			switch {
+			case b == cmdIAC:
+				t.state = stateIAC
+			case b == charNUL:
+				// CR NUL is a bare carriage return, so only the CR is
+				// kept. NUL anywhere else is padding.
+			case shouldFilter(b):
+				// Filter unwanted control characters from regular data
+			default:
+				n := t.decodeByte(p[out:], b, cm, out == 0)
+				if n == 0 {
+					return t.debugData(p[:out])
+				}
+				out += n
+			}
+
+		case stateIAC:
+			switch b {
+			case cmdIAC:
+				// Escaped IAC byte. If it doesn't fit the state is kept
+				// so it is retried on the next call.
+				n := t.decodeByte(p[out:], cmdIAC, cm, out == 0)
+				if n == 0 {
+					return t.debugData(p[:out])
+				}
+				out += n
+				t.state = stateData
+			case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+				t.command = b
+				t.state = stateNegotiate
+			case cmdSB:
+				t.state = stateSB
+			case cmdGA, cmdEOR:
+				// End of a prompt. Data before the marker has to reach the
+				// reader before the prompt is reported, so stop here and
+				// leave the rest for the next Read.
+				t.state = stateData
+				if out == 0 {
+					t.dispatch("Prompt", []TelnetEvent{PromptEvent{}})
+					continue
+				}
+				src.skip()
+				t.promptPending = true
+				return t.debugData(p[:out])
+			default:
+				// Simple commands (NOP, AYT, ...) carry no data
+				t.state = stateData
+			}
+
+		case stateNegotiate:
+			t.state = stateData
+			if t.report("Command", t.handleCommand([]byte{cmdIAC, t.command, b}), out) {
+				src.skip()
+				return t.debugData(p[:out])
+			}
+
+		case stateSB:
+			t.sbOption = b
+			t.sbBuffer = t.sbBuffer[:0]
+			t.sbOverflow = false
+			t.state = stateSBData
+
+		case stateSBData:
+			if b == cmdIAC {
+				t.state = stateSBIAC
+				continue
+			}
+			t.appendSubneg(b)
+
+		case stateSBIAC:
+			switch b {
+			case cmdSE:
+				t.state = stateData
+				if t.sbOverflow {
+					if t.debug {
+						fmt.Printf("Dropped subnegotiation for option %d over %d bytes\n", t.sbOption, t.maxSubneg)
+					}
+					continue
+				}
+				held := t.report("Subnegotiation", t.handleSubnegotiation(t.sbOption, t.sbBuffer), out)
+				if t.sbOption == optMCCP2 && !t.compressing && t.inboundCompressionEnabled() {
+					// Everything after IAC SE is compressed and has to go
+					// through the inflater
+					src.skip()
+					t.startCompression()
+					return t.debugData(p[:out])
+				}
+				if held {
+					src.skip()
+					return t.debugData(p[:out])
+				}
+				// The subnegotiation may have changed the encoding
+				cm = t.charmap()
+			case cmdIAC:
+				// Escaped IAC byte within the payload
+				t.appendSubneg(cmdIAC)
+				t.state = stateSBData
+			default:
+				// Malformed sequence, keep collecting the payload
+				t.state = stateSBData
+			}
+		}
+	}
+	return t.debugData(p[:out])
+}
+
+// report dispatches events parsed after out bytes of data. If they change
+// how the following text is handled, as MXP or MSP being switched on or off
+// does, and there is data before them, they are held until that data has
+// been returned and report returns true so parsing stops there.
+func (t *TelnetConnection) report(kind string, events []TelnetEvent, out int) bool {
+	if out > 0 {
+		for _, e := range events {
+			switch e.(type) {
+			case MXPEvent, MSPEvent:
+				t.held = events
+				t.heldKind = kind
+				return true
+			}
+		}
+	}
+	t.dispatch(kind, events)
+	return false
+}
+
+// appendSubneg adds a byte to the subnegotiation payload, dropping the
+// payload once it grows past the limit
+func (t *TelnetConnection) appendSubneg(b byte) {
+	if t.sbOverflow {
+		return
+	}
+	if len(t.sbBuffer) >= t.maxSubneg {
+		t.sbOverflow = true
+		t.sbBuffer = t.sbBuffer[:0]
+		return
+	}
+	t.sbBuffer = append(t.sbBuffer, b)
+}
+
+// debugData dumps received data when debugging and returns its length.
+func (t *TelnetConnection) debugData(data []byte) int {
+	if t.debug && len(data) > 0 {
+		fmt.Printf("Data received: \n%s", hexDump(data))
+	}
+	return len(data)
+}
+
+// rawReader feeds the inflater from the raw buffer, refilling it from the
+// connection as needed. It implements io.ByteReader so the inflater reads
+// exactly up to the end of a compressed stream, leaving anything after it
+// buffered.
+type rawReader struct {
+	t *TelnetConnection
+}
+
+func (r rawReader) Read(p []byte) (int, error) {
+	if err := r.t.fillRaw(); err != nil {
+		return 0, connError{err}
+	}
+	n := r.t.raw.read(p)
+	r.t.stats.compressed.Add(int64(n))
+	return n, nil
+}
+
+func (r rawReader) ReadByte() (byte, error) {
+	if err := r.t.fillRaw(); err != nil {
+		return 0, connError{err}
+	}
+	b := r.t.raw.peek()
+	r.t.raw.skip()
+	r.t.stats.compressed.Add(1)
+	return b, nil
+}
+
+// connError carries an error reading the connection through the inflater,
+// so it isn't mistaken for a broken compressed stream
+type connError struct {
+	err error
+}
+
+func (e connError) Error() string {
+	return e.err.Error()
+}
+
+// fillRaw reads from the connection if the raw buffer is empty
+func (t *TelnetConnection) fillRaw() error {
+	if t.raw.Len() > 0 {
+		return nil
+	}
+	n, err := t.raw.fill(t.readConn)
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
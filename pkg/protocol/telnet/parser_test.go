@@ -0,0 +1,251 @@
+package telnet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkConn is a net.Conn that returns each chunk from a separate Read and
+// records what is written to it
+type chunkConn struct {
+	chunks  [][]byte
+	written bytes.Buffer
+}
+
+func (c *chunkConn) Read(p []byte) (int, error) {
+	for len(c.chunks) > 0 && len(c.chunks[0]) == 0 {
+		c.chunks = c.chunks[1:]
+	}
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks[0] = c.chunks[0][n:]
+	return n, nil
+}
+
+func (c *chunkConn) Write(p []byte) (int, error)        { return c.written.Write(p) }
+func (c *chunkConn) Close() error                       { return nil }
+func (c *chunkConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *chunkConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *chunkConn) SetDeadline(t time.Time) error      { return nil }
+func (c *chunkConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *chunkConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// parseResult is everything observable from parsing a stream
+type parseResult struct {
+	data    string // Data read, with "|" where prompts were reported
+	events  []string
+	replies []byte
+	err     string
+
+	truncated bool // Reading stopped at maxParseOutput
+}
+
+// maxParseOutput bounds the data read by parseChunks, as a few bytes of
+// compressed input can inflate to far more
+const maxParseOutput = 64 * 1024
+
+// parseChunks parses the chunks as one stream, reading with a small buffer
+// so output boundaries are exercised too
+func parseChunks(encoding string, chunks ...[]byte) parseResult {
+	conn := &chunkConn{chunks: chunks}
+	client := newTelnetConnection(conn, false)
+	client.SetEncoding(encoding)
+	client.SetMaxSubnegotiation(16)
+
+	var res parseResult
+	var data strings.Builder
+	client.SetEventHandler(func(e TelnetEvent) {
+		if _, ok := e.(PromptEvent); ok {
+			data.WriteByte('|')
+			return
+		}
+		res.events = append(res.events, fmt.Sprintf("%#v", e))
+	})
+
+	buf := make([]byte, 5)
+	for {
+		n, err := client.Read(buf)
+		data.Write(buf[:n])
+		if data.Len() > maxParseOutput {
+			res.truncated = true
+			break
+		}
+		if err != nil {
+			if err != io.EOF {
+				res.err = err.Error()
+			}
+			break
+		}
+	}
+	res.data = data.String()
+	res.replies = conn.written.Bytes()
+	return res
+}
+
+func (r parseResult) equal(o parseResult) bool {
+	return r.data == o.data && r.err == o.err && bytes.Equal(r.replies, o.replies) &&
+		fmt.Sprint(r.events) == fmt.Sprint(o.events)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		events int
+	}{
+		{"plain text", "Hello\r\n", "Hello\r\n", 0},
+		{"CR NUL is a bare CR", "a\r\x00b\r\n", "a\rb\r\n", 0},
+		{"escaped IAC", "a\xff\xffb", "a\xffb", 0},
+		{"control characters filtered", "a\x01\x1b[0mb", "a\x1b[0mb", 0},
+		{"simple commands dropped", "a\xff\xf1b\xff\xf6c", "abc", 0},
+		{"IAC IAC inside SB", "\xff\xfa\x18a\xff\xffb\xff\xf0x", "x", 1},
+		{"oversized SB dropped", "\xff\xfa\xc9" + strings.Repeat("x", 17) + "\xff\xf0ok", "ok", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseChunks(EncodingUTF8, []byte(tt.input))
+			if got.data != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got.data)
+			}
+			if len(got.events) != tt.events {
+				t.Errorf("expected %d events, got %v", tt.events, got.events)
+			}
+		})
+	}
+}
+
+func TestParseSubnegotiationPayload(t *testing.T) {
+	var got SubnegotiationEvent
+	client := newTelnetConnection(&chunkConn{chunks: [][]byte{
+		[]byte("\xff\xfa\x18a\xff"), []byte("\xffb\xff"), []byte("\xf0"),
+	}}, false)
+	client.SetEventHandler(func(e TelnetEvent) {
+		if e, ok := e.(SubnegotiationEvent); ok {
+			got = e
+		}
+	})
+	readAll(client)
+
+	if want := "a\xffb"; got.Option != optTERMINAL_TYPE || string(got.Data) != want {
+		t.Errorf("expected payload %q for option %d, got %+v", want, optTERMINAL_TYPE, got)
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	b := newRingBuffer(8)
+	write := func(s string) {
+		b.fill(func(p []byte) (int, error) { return copy(p, s), nil })
+	}
+
+	write("abcdef")
+	got := make([]byte, 4)
+	b.read(got)
+	if string(got) != "abcd" {
+		t.Fatalf("expected abcd, got %q", got)
+	}
+
+	// The free space wraps around to the start of the buffer
+	write("gh")
+	write("ijkl")
+	if b.Len() != 8 {
+		t.Fatalf("expected 8 unread bytes, got %d", b.Len())
+	}
+	got = make([]byte, 8)
+	if n := b.read(got); string(got[:n]) != "efghijkl" {
+		t.Errorf("expected efghijkl, got %q", got[:n])
+	}
+}
+
+func TestReadDoesNotAllocate(t *testing.T) {
+	chunk := []byte("You see a long corridor.\r\nHP 100> ")
+	conn := &chunkConn{}
+	client := newTelnetConnection(conn, false)
+	buf := make([]byte, 64)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		conn.chunks = append(conn.chunks[:0], chunk)
+		if _, err := client.Read(buf); err != nil {
+			t.Fatal("Read failed:", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations per Read, got %v", allocs)
+	}
+}
+
+// fuzzSeeds are streams mixing data with every kind of telnet sequence
+var fuzzSeeds = []string{
+	"Hello\r\nHP 100> \xff\xf9more\r\n",
+	"a\r\x00b\r\nc\xff\xffd",
+	"\xff\xfb\x01\xff\xfd\x18\xff\xfa\x18\x01\xff\xf0",
+	"\xff\xfa\xc9Core.Ping {\"a\":\"\xff\xff\"}\xff\xf0",
+	"\xff\xfb\x2a\xff\xfa\x2a\x02ISO-8859-1\xff\xf0caf\xe9\xff\xef",
+	"\xff\xfa\x46\x01NAME\x02Test\xff\xf0",
+	"\xff\xfa\xc9" + strings.Repeat("long", 8) + "\xff\xf0after",
+	"\xff\xfa\x56\xff\xf0" + string(compress([]byte("inside\r\n\xff\xf9x"))) + "after",
+	"\xff\xfa\x56\xff\xf0000",
+}
+
+// maxFuzzInput keeps fuzz inputs small enough that parsing every split
+// stays fast
+const maxFuzzInput = 1024
+
+// FuzzSplit checks that splitting the input at any byte position gives the
+// same result as parsing it in one piece
+func FuzzSplit(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed), false)
+		f.Add([]byte(seed), true)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, latin1 bool) {
+		if len(data) > maxFuzzInput {
+			return
+		}
+		encoding := EncodingUTF8
+		if latin1 {
+			encoding = EncodingLatin1
+		}
+		want := parseChunks(encoding, data)
+		if want.truncated {
+			return
+		}
+		for i := 1; i < len(data); i++ {
+			got := parseChunks(encoding, data[:i:i], data[i:])
+			if !got.equal(want) {
+				t.Fatalf("split at %d: expected %+v, got %+v", i, want, got)
+			}
+		}
+	})
+}
+
+// FuzzByteAtATime checks that reading the input one byte at a time gives the
+// same result as parsing it in one piece
+func FuzzByteAtATime(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		want := parseChunks(EncodingUTF8, data)
+		if want.truncated {
+			return
+		}
+		chunks := make([][]byte, len(data))
+		for i := range data {
+			chunks[i] = data[i : i+1 : i+1]
+		}
+		if got := parseChunks(EncodingUTF8, chunks...); !got.equal(want) {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package telnet
+
+import (
+	"bytes"
+	"testing"
+)
+
+// readMarked reads everything from the connection, writing "|" where
+// prompts are reported
+func readMarked(t *testing.T, client *TelnetConnection) string {
+	t.Helper()
+	var out bytes.Buffer
+	client.SetEventHandler(func(e TelnetEvent) {
+		if _, ok := e.(PromptEvent); ok {
+			out.WriteByte('|')
+		}
+	})
+	buf := make([]byte, 64)
+	for {
+		n, err := client.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			return out.String()
+		}
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes [][]byte
+		want   string
+	}{
+		{
+			name:   "GA after text",
+			writes: [][]byte{[]byte("Hello\r\nHP 100> \xff\xf9more\r\n")},
+			want:   "Hello\r\nHP 100> |more\r\n",
+		},
+		{
+			name:   "EOR",
+			writes: [][]byte{[]byte("Name? \xff\xef")},
+			want:   "Name? |",
+		},
+		{
+			name:   "prompt split across reads",
+			writes: [][]byte{[]byte("HP 1"), []byte("00> \xff"), []byte("\xf9look\r\n")},
+			want:   "HP 100> |look\r\n",
+		},
+		{
+			name:   "several prompts in one read",
+			writes: [][]byte{[]byte("a> \xff\xf9\xff\xf9b> \xff\xef")},
+			want:   "a> ||b> |",
+		},
+		{
+			name:   "prompt at start of read",
+			writes: [][]byte{[]byte("> "), []byte("\xff\xf9x")},
+			want:   "> |x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := dialTestServer(t)
+			go func() {
+				for _, w := range tt.writes {
+					server.Write(w)
+				}
+				server.Close()
+			}()
+
+			if got := readMarked(t, client); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPromptBeforeCompression(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	// Data held back after a prompt must come before the compressed stream
+	data := []byte("hp> \xff\xf9\xff\xfa\x56\xff\xf0")
+	data = append(data, compress([]byte("inside\r\n"))...)
+	go func() {
+		if !enableMCCP2(t, server) {
+			server.Close()
+			return
+		}
+		server.Write(data)
+		server.Close()
+	}()
+
+	if got, want := readMarked(t, client), "hp> |inside\r\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
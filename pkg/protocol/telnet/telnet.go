@@ -2,8 +2,13 @@ package telnet
 
 import (
 	"bytes"
+	"compress/zlib"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // Telnet commands (RFC 854)
@@ -46,10 +51,12 @@ const (
 	optSTATUS        = 5
 	optTIMING_MARK   = 6
 	optTERMINAL_TYPE = 24
+	optEOR           = 25 // End of Record, marks prompts like GA
 	optWINDOW_SIZE   = 31
 	optTERM_SPEED    = 32
 	optLINEMODE      = 34
 	optNEW_ENVIRON   = 39
+	optCHARSET       = 42
 	optMSDP          = 69  // MUD Server Data Protocol
 	optMSSP          = 70  // MUD Server Status Protocol
 	optMCCP2         = 86  // MUD Client Compression Protocol v2
@@ -67,177 +74,184 @@ func shouldFilter(b byte) bool {
 		b != 0x1B  // Preserve escape character for ANSI sequences
 }
 
-// OptionState represents the state of a telnet option
-type OptionState struct {
-	Supported     bool
-	LocalEnabled  bool
-	RemoteEnabled bool
-}
-
 // TelnetConnection implements the Connection interface for telnet connections
 type TelnetConnection struct {
-	host  string
-	port  int
-	conn  net.Conn
-	debug bool
-
-	// Buffers for processing telnet protocol
-	cmdBuffer []byte
-	inCommand bool
-	inSubneg  bool
-	options   map[byte]OptionState
+	conn    net.Conn
+	debug   bool
+	handler func(TelnetEvent)
+
+	// Telnet stream parsing. raw holds bytes read from the connection and
+	// inflated holds the output of an MCCP2 stream; the parser works on
+	// whichever is current.
+	raw        ringBuffer
+	inflated   ringBuffer
+	readErr    error
+	state      parseState
+	command    byte
+	sbOption   byte
+	sbBuffer   []byte
+	sbOverflow bool
+	maxSubneg  int
+
+	// Whether a prompt marker has been parsed but not reported yet
+	promptPending bool
+
+	// Events that change how the following text is handled, such as MXP
+	// or MSP starting, held until the text before them has been returned
+	held     []TelnetEvent
+	heldKind string
+
+	// The part of a decoded character that didn't fit in the caller's buffer
+	spill    []byte
+	spillBuf [utf8.UTFMax]byte
+
+	// Receives a copy of everything read from the connection
+	recordMu sync.Mutex
+	recorder io.Writer
+
+	mu      sync.Mutex // Guards options and protocol settings
+	options map[byte]*option
+
+	gmcpSupports []string
+	msdpPending  [][]byte
+	mssp         map[string][]string
+	cols, rows   int
+	encoding     string
+	termType     string
+	mtts         int
+	ttypeIndex   int
+	userVars     map[string]string         // Custom NEW-ENVIRON variables
+	environSent  map[environKey]environVar // NEW-ENVIRON values the server has been sent
+
+	// Inbound compression (MCCP2)
+	compressing bool
+	inflater    io.ReadCloser
+	stats       compressionCounters
+
+	// Outbound data, compressed once MCCP3 starts
+	writeMu  sync.Mutex
+	deflater *zlib.Writer
 }
 
-// NewTelnetConnection creates a new telnet connection
-func NewTelnetConnection(host string, port int, debug bool) (*TelnetConnection, error) {
-	conn, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
-	if err != nil {
-		return nil, err
-	}
+// FromConn creates a telnet connection over an established connection, such
+// as one end of a pipe replaying a recording
+func FromConn(conn net.Conn, debug bool) *TelnetConnection {
+	return newTelnetConnection(conn, debug)
+}
 
+// newTelnetConnection sets up telnet processing on an established connection
+func newTelnetConnection(conn net.Conn, debug bool) *TelnetConnection {
 	t := &TelnetConnection{
-		host:      host,
-		port:      port,
 		conn:      conn,
 		debug:     debug,
-		cmdBuffer: make([]byte, 0, 3),
-		options:   make(map[byte]OptionState),
+		raw:       newRingBuffer(readBufferSize),
+		maxSubneg: DefaultMaxSubnegotiation,
+		options:   make(map[byte]*option),
+		cols:      80,
+		rows:      24,
+		encoding:  EncodingUTF8,
 	}
 
 	// Set up supported options
-	t.options[optSUPPRESS_GA] = OptionState{Supported: true}
-	t.options[optMCCP2] = OptionState{Supported: true}
-	t.options[optGMCP] = OptionState{Supported: true}
+	for _, opt := range []byte{optECHO, optSUPPRESS_GA, optMCCP2, optMCCP3, optGMCP, optMSDP, optMSSP, optMSP, optMXP, optEOR, optCHARSET} {
+		t.side(opt, false).supported = true
+	}
+	for _, opt := range []byte{optMXP, optWINDOW_SIZE, optTERMINAL_TYPE, optNEW_ENVIRON, optCHARSET} {
+		t.side(opt, true).supported = true
+	}
 
-	return t, nil
+	return t
 }
 
-func (t *TelnetConnection) Read(p []byte) (int, error) {
-	n, err := t.conn.Read(p)
-	if err != nil {
-		return n, err
+// dispatch reports telnet events produced while parsing.
+func (t *TelnetConnection) dispatch(kind string, events []TelnetEvent) {
+	if t.debug && len(events) > 0 {
+		fmt.Printf("%s events: %v\n", kind, events)
 	}
-
-	output := make([]byte, len(p)*2)
-	outIndex := 0
-	dataStart := 0
-
-	for i := 0; i < n; i++ {
-		b := p[i]
-
-		// Handle telnet commands
-		if b == cmdIAC {
-			// Copy any pending data before handling the command
-			if dataStart < i {
-				copy(output[outIndex:], p[dataStart:i])
-				outIndex += i - dataStart
-				if t.debug {
-					fmt.Printf("Data received: \n%s", hexDump(p[dataStart:i]))
-				}
-			}
-			dataStart = i + 1
-
-			t.inCommand = true
-			t.cmdBuffer = t.cmdBuffer[:0]
-			t.cmdBuffer = append(t.cmdBuffer, b)
-			continue
-		}
-
-		if t.inCommand {
-			t.cmdBuffer = append(t.cmdBuffer, b)
-
-			// Handle different command types
-			switch len(t.cmdBuffer) {
-			case 2:
-				// Two-byte commands
-				switch t.cmdBuffer[1] {
-				case cmdSB:
-					t.inSubneg = true
-					continue
-				case cmdIAC:
-					// Escaped IAC byte
-					output[outIndex] = cmdIAC
-					outIndex++
-					t.inCommand = false
-					dataStart = i + 1
-				case cmdGA, cmdEL, cmdEC, cmdAYT, cmdAO, cmdIP, cmdBRK, cmdDM, cmdNOP:
-					// Simple commands
-					t.inCommand = false
-					dataStart = i + 1
-				}
-			case 3:
-				// Three-byte negotiation commands
-				events := t.handleCommand(t.cmdBuffer)
-				if t.debug && len(events) > 0 {
-					fmt.Printf("Command events: %v\n", events)
-				}
-				t.inCommand = false
-				dataStart = i + 1
-			}
-			continue
-		}
-
-		if t.inSubneg {
-			t.cmdBuffer = append(t.cmdBuffer, b)
-			if b == cmdSE && len(t.cmdBuffer) >= 2 && t.cmdBuffer[len(t.cmdBuffer)-2] == cmdIAC {
-				events := t.handleSubnegotiation(t.cmdBuffer)
-				if t.debug && len(events) > 0 {
-					fmt.Printf("Subnegotiation events: %v\n", events)
-				}
-				t.cmdBuffer = t.cmdBuffer[:0]
-				t.inSubneg = false
-				dataStart = i + 1
-			}
-			continue
-		}
-
-		// Filter unwanted control characters from regular data
-		if shouldFilter(b) {
-			if dataStart == i {
-				dataStart = i + 1
-			} else {
-				// Copy data up to this point
-				copy(output[outIndex:], p[dataStart:i])
-				outIndex += i - dataStart
-				dataStart = i + 1
-			}
-			continue
-		}
+	if t.handler == nil {
+		return
 	}
-
-	// Copy any remaining data
-	if dataStart < n {
-		copy(output[outIndex:], p[dataStart:n])
-		outIndex += n - dataStart
-		if t.debug {
-			fmt.Printf("Data received: \n%s", hexDump(p[dataStart:n]))
-		}
+	for _, event := range events {
+		t.handler(event)
 	}
+}
 
-	copy(p, output[:outIndex])
-	return outIndex, nil
+// SetEventHandler registers a function that receives the protocol events
+// (GMCP messages, prompts and so on) parsed from the stream. It is called
+// from the goroutine calling Read, or for option changes requested with
+// EnableLocal and the like from their caller, and must be set before reading
+// starts.
+func (t *TelnetConnection) SetEventHandler(handler func(TelnetEvent)) {
+	t.handler = handler
 }
 
 func (t *TelnetConnection) Write(p []byte) (n int, err error) {
 	// Escape any IAC bytes in the data
-	var escaped []byte
-	for _, b := range p {
-		if b == cmdIAC {
-			escaped = append(escaped, cmdIAC, cmdIAC)
-		} else {
-			escaped = append(escaped, b)
-		}
+	escaped := appendEscaped(make([]byte, 0, len(p)), p)
+	if err := t.send(escaped); err != nil {
+		return 0, err
 	}
-	return t.conn.Write(escaped)
+	return len(p), nil
+}
+
+// SendSubnegotiation sends IAC SB <option> <data> IAC SE, escaping any IAC
+// bytes in data
+func (t *TelnetConnection) SendSubnegotiation(option byte, data []byte) error {
+	msg := []byte{cmdIAC, cmdSB, option}
+	msg = appendEscaped(msg, data)
+	msg = append(msg, cmdIAC, cmdSE)
+	return t.send(msg)
+}
+
+// SendNOP sends IAC NOP, which servers ignore. A failed write shows the
+// connection is gone.
+func (t *TelnetConnection) SendNOP() error {
+	return t.send([]byte{cmdIAC, cmdNOP})
+}
+
+// SendAYT sends IAC AYT (Are You There), which servers answer with a line
+// of text, showing the connection still works both ways
+func (t *TelnetConnection) SendAYT() error {
+	return t.send([]byte{cmdIAC, cmdAYT})
 }
 
 func (t *TelnetConnection) Close() error {
+	t.endOutboundCompression()
 	if t.conn != nil {
 		return t.conn.Close()
 	}
 	return nil
 }
 
+// SetRecorder sets a writer that receives every chunk read from the
+// connection exactly as it arrived, telnet commands and compressed data
+// included. Nil stops recording; once SetRecorder returns the old writer
+// won't be written to again.
+func (t *TelnetConnection) SetRecorder(w io.Writer) {
+	t.recordMu.Lock()
+	t.recorder = w
+	t.recordMu.Unlock()
+}
+
+// readConn reads from the connection, passing what it reads to the recorder
+func (t *TelnetConnection) readConn(p []byte) (int, error) {
+	n, err := t.conn.Read(p)
+	if n > 0 {
+		t.recordMu.Lock()
+		if t.recorder != nil {
+			t.recorder.Write(p[:n])
+		}
+		t.recordMu.Unlock()
+	}
+	return n, err
+}
+
+// SetReadDeadline sets when a blocked Read gives up waiting for the server. A
+// zero time waits forever.
+func (t *TelnetConnection) SetReadDeadline(deadline time.Time) error {
+	return t.conn.SetReadDeadline(deadline)
+}
+
 // hexDump returns a hex+ASCII dump of data for debugging
 func hexDump(data []byte) string {
 	if len(data) == 0 {
@@ -278,62 +292,109 @@ type NegotiationEvent struct {
 	Option  byte
 }
 
+// CommandName returns the name of a negotiation command, e.g. "WILL"
+func CommandName(cmd byte) string {
+	switch cmd {
+	case cmdWILL:
+		return "WILL"
+	case cmdWONT:
+		return "WONT"
+	case cmdDO:
+		return "DO"
+	case cmdDONT:
+		return "DONT"
+	}
+	return fmt.Sprintf("%d", cmd)
+}
+
 type SubnegotiationEvent struct {
 	Option byte
 	Data   []byte
 }
 
+// PromptEvent marks the end of a prompt (IAC GA or IAC EOR). It is reported
+// after the prompt's text has been returned by Read.
+type PromptEvent struct{}
+
+// EchoEvent reports the server taking over echoing of input (ECHO enabled on
+// its side), as servers do while asking for a password, or handing it back
+type EchoEvent struct {
+	Enabled bool
+}
+
+// MXPEvent reports MXP being switched on or off for the connection
+type MXPEvent struct {
+	Enabled bool
+}
+
+// MSPEvent reports the server switching MSP on or off. Sound triggers are
+// only looked for in the text while it is on.
+type MSPEvent struct {
+	Enabled bool
+}
+
 func (t *TelnetConnection) handleCommand(cmd []byte) []TelnetEvent {
 	if len(cmd) != 3 {
 		return nil
 	}
 
-	var events []TelnetEvent
+	var local, enable bool
 	switch cmd[1] {
 	case cmdWILL:
-		events = append(events, NegotiationEvent{Command: cmdWILL, Option: cmd[2]})
-		if state, ok := t.options[cmd[2]]; ok && state.Supported {
-			t.conn.Write([]byte{cmdIAC, cmdDO, cmd[2]})
-			state.RemoteEnabled = true
-			t.options[cmd[2]] = state
-		} else {
-			t.conn.Write([]byte{cmdIAC, cmdDONT, cmd[2]})
-		}
+		enable = true
 	case cmdWONT:
-		events = append(events, NegotiationEvent{Command: cmdWONT, Option: cmd[2]})
-		if state, ok := t.options[cmd[2]]; ok {
-			state.RemoteEnabled = false
-			t.options[cmd[2]] = state
-		}
-		t.conn.Write([]byte{cmdIAC, cmdDONT, cmd[2]})
 	case cmdDO:
-		events = append(events, NegotiationEvent{Command: cmdDO, Option: cmd[2]})
-		if state, ok := t.options[cmd[2]]; ok && state.Supported {
-			t.conn.Write([]byte{cmdIAC, cmdWILL, cmd[2]})
-			state.LocalEnabled = true
-			t.options[cmd[2]] = state
-		} else {
-			t.conn.Write([]byte{cmdIAC, cmdWONT, cmd[2]})
-		}
+		local, enable = true, true
 	case cmdDONT:
-		events = append(events, NegotiationEvent{Command: cmdDONT, Option: cmd[2]})
-		if state, ok := t.options[cmd[2]]; ok {
-			state.LocalEnabled = false
-			t.options[cmd[2]] = state
-		}
-		t.conn.Write([]byte{cmdIAC, cmdWONT, cmd[2]})
+		local = true
+	default:
+		return nil
 	}
-	return events
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	events := []TelnetEvent{NegotiationEvent{Command: cmd[1], Option: cmd[2]}}
+	return append(events, t.receiveOption(cmd[2], local, enable)...)
 }
 
-func (t *TelnetConnection) handleSubnegotiation(cmd []byte) []TelnetEvent {
-	if len(cmd) < 4 {
-		return nil
+func (t *TelnetConnection) handleSubnegotiation(option byte, data []byte) []TelnetEvent {
+	if option == optMCCP3 {
+		// Some servers prompt the client to start compressing
+		t.mu.Lock()
+		if t.remoteEnabled(optMCCP3) {
+			t.beginOutboundCompression()
+		}
+		t.mu.Unlock()
 	}
 
 	var events []TelnetEvent
-	option := cmd[2]
-	data := cmd[3 : len(cmd)-2] // Remove IAC SE at the end
-	events = append(events, SubnegotiationEvent{Option: option, Data: data})
+	payload := make([]byte, len(data))
+	copy(payload, data)
+	events = append(events, SubnegotiationEvent{Option: option, Data: payload})
+
+	switch option {
+	case optGMCP:
+		events = append(events, parseGMCP(payload))
+	case optMSDP:
+		for _, msg := range parseMSDP(payload) {
+			events = append(events, msg)
+		}
+	case optTERMINAL_TYPE:
+		t.handleTerminalType(payload)
+	case optNEW_ENVIRON:
+		t.handleEnviron(payload)
+	case optCHARSET:
+		events = append(events, t.handleCharset(payload)...)
+	case optMXP:
+		// Servers send an empty subnegotiation to start MXP
+		events = append(events, MXPEvent{Enabled: true})
+	case optMSSP:
+		msg := parseMSSP(payload)
+		t.mu.Lock()
+		t.mssp = msg.Variables
+		t.mu.Unlock()
+		events = append(events, msg)
+	}
 	return events
 }
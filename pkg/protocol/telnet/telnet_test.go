@@ -0,0 +1,221 @@
+package telnet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mmcdole/runes/pkg/mudtest"
+)
+
+func TestSendSubnegotiation(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	go client.SendSubnegotiation(200, []byte{'h', 'i', cmdIAC})
+	expectBytes(t, server, []byte{cmdIAC, cmdSB, 200, 'h', 'i', cmdIAC, cmdIAC, cmdIAC, cmdSE})
+}
+
+func TestNegotiationEvents(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	events := make(chan TelnetEvent, 10)
+	client.SetEventHandler(func(e TelnetEvent) {
+		switch e.(type) {
+		case NegotiationEvent, SubnegotiationEvent:
+			events <- e
+		}
+	})
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdWILL, 200, cmdIAC, cmdSB, 200, 'x', cmdIAC, cmdIAC, cmdIAC, cmdSE})
+	if e := <-events; e != (NegotiationEvent{Command: cmdWILL, Option: 200}) {
+		t.Errorf("expected WILL 200, got %+v", e)
+	}
+	e, ok := (<-events).(SubnegotiationEvent)
+	if !ok || e.Option != 200 || string(e.Data) != "x\xff" {
+		t.Errorf("expected subnegotiation of 200 with %q, got %+v", "x\xff", e)
+	}
+	if name := CommandName(cmdDONT); name != "DONT" {
+		t.Errorf("expected DONT, got %s", name)
+	}
+}
+
+func TestServerEcho(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	events := make(chan EchoEvent, 10)
+	client.SetEventHandler(func(e TelnetEvent) {
+		if e, ok := e.(EchoEvent); ok {
+			events <- e
+		}
+	})
+	go readAll(client)
+
+	// A password prompt: the server echoes (nothing) until the line is sent
+	server.Write([]byte{cmdIAC, cmdWILL, optECHO})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdDO, optECHO}) {
+		return
+	}
+	if e := <-events; !e.Enabled {
+		t.Errorf("expected echo to be enabled, got %+v", e)
+	}
+
+	server.Write([]byte{cmdIAC, cmdWONT, optECHO})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdDONT, optECHO}) {
+		return
+	}
+	if e := <-events; e.Enabled {
+		t.Errorf("expected echo to be disabled, got %+v", e)
+	}
+}
+
+func TestMSPNegotiation(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	events := make(chan MSPEvent, 10)
+	client.SetEventHandler(func(e TelnetEvent) {
+		if e, ok := e.(MSPEvent); ok {
+			events <- e
+		}
+	})
+	go readAll(client)
+
+	// The triggers themselves arrive in the text, MSP only announces them
+	server.Write([]byte{cmdIAC, cmdWILL, optMSP})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdDO, optMSP}) {
+		return
+	}
+	if e := <-events; !e.Enabled {
+		t.Errorf("expected MSP to be enabled, got %+v", e)
+	}
+
+	server.Write([]byte{cmdIAC, cmdWONT, optMSP})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdDONT, optMSP}) {
+		return
+	}
+	if e := <-events; e.Enabled {
+		t.Errorf("expected MSP to be disabled, got %+v", e)
+	}
+}
+
+func TestMXPEventOrder(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	// Text before MXP is switched on mustn't be handled as MXP, so the
+	// switch is reported at its place in the stream
+	stream := []byte("before\r\n")
+	stream = append(stream, cmdIAC, cmdWILL, optMXP)
+	stream = append(stream, "after\r\n"...)
+	stream = append(stream, cmdIAC, cmdWONT, optMXP)
+	stream = append(stream, "end\r\n"...)
+	go func() {
+		server.Write(stream)
+		server.Close()
+	}()
+
+	var out bytes.Buffer
+	client.SetEventHandler(func(e TelnetEvent) {
+		if e, ok := e.(MXPEvent); ok {
+			if e.Enabled {
+				out.WriteByte('+')
+			} else {
+				out.WriteByte('-')
+			}
+		}
+	})
+	buf := make([]byte, 64)
+	for {
+		n, err := client.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	if want := "before\r\n+after\r\n-end\r\n"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	var recorded bytes.Buffer
+	client.SetRecorder(&recorded)
+	stream := append([]byte{cmdIAC, cmdWILL, optECHO}, "Password: "...)
+	stream = append(stream, compress([]byte("not a telnet command"))...)
+	go func() {
+		server.Write(stream)
+		server.Close()
+	}()
+
+	if _, err := readAll(client); err != nil {
+		t.Fatal("Read failed:", err)
+	}
+	if !bytes.Equal(recorded.Bytes(), stream) {
+		t.Errorf("expected the raw stream to be recorded, got %q", recorded.Bytes())
+	}
+}
+
+func TestProbes(t *testing.T) {
+	client, server := dialTestServer(t)
+
+	if err := client.SendNOP(); err != nil {
+		t.Fatal("SendNOP failed:", err)
+	}
+	expectBytes(t, server, []byte{cmdIAC, cmdNOP})
+	if err := client.SendAYT(); err != nil {
+		t.Fatal("SendAYT failed:", err)
+	}
+	expectBytes(t, server, []byte{cmdIAC, cmdAYT})
+
+	// A NOP from the server is skipped
+	go func() {
+		server.Write([]byte{cmdIAC, cmdNOP})
+		server.Write([]byte("[Yes]\r\n"))
+		server.Close()
+	}()
+	got, err := readAll(client)
+	if err != nil || string(got) != "[Yes]\r\n" {
+		t.Errorf("expected only the text, got %q %v", got, err)
+	}
+}
+
+// TestMudtestConstants pins mudtest's copies of the telnet commands and
+// options to this package's values
+func TestMudtestConstants(t *testing.T) {
+	tests := []struct {
+		name    string
+		mudtest byte
+		telnet  byte
+	}{
+		{"IAC", mudtest.IAC, cmdIAC},
+		{"DONT", mudtest.DONT, cmdDONT},
+		{"DO", mudtest.DO, cmdDO},
+		{"WONT", mudtest.WONT, cmdWONT},
+		{"WILL", mudtest.WILL, cmdWILL},
+		{"SB", mudtest.SB, cmdSB},
+		{"GA", mudtest.GA, cmdGA},
+		{"AYT", mudtest.AYT, cmdAYT},
+		{"NOP", mudtest.NOP, cmdNOP},
+		{"SE", mudtest.SE, cmdSE},
+		{"EOR", mudtest.EOR, cmdEOR},
+		{"OptEcho", mudtest.OptEcho, optECHO},
+		{"OptSuppressGA", mudtest.OptSuppressGA, optSUPPRESS_GA},
+		{"OptTerminalType", mudtest.OptTerminalType, optTERMINAL_TYPE},
+		{"OptEOR", mudtest.OptEOR, optEOR},
+		{"OptNAWS", mudtest.OptNAWS, optWINDOW_SIZE},
+		{"OptNewEnviron", mudtest.OptNewEnviron, optNEW_ENVIRON},
+		{"OptCharset", mudtest.OptCharset, optCHARSET},
+		{"OptMSDP", mudtest.OptMSDP, optMSDP},
+		{"OptMSSP", mudtest.OptMSSP, optMSSP},
+		{"OptMCCP2", mudtest.OptMCCP2, optMCCP2},
+		{"OptMCCP3", mudtest.OptMCCP3, optMCCP3},
+		{"OptMSP", mudtest.OptMSP, optMSP},
+		{"OptMXP", mudtest.OptMXP, optMXP},
+		{"OptGMCP", mudtest.OptGMCP, optGMCP},
+	}
+	for _, test := range tests {
+		if test.mudtest != test.telnet {
+			t.Errorf("mudtest.%s is %d, telnet uses %d", test.name, test.mudtest, test.telnet)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package telnet
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig controls how the server's certificate is checked. The zero value
+// verifies it against the system roots.
+type TLSConfig struct {
+	CAFile       string   // PEM file with the CAs to trust instead of the system roots
+	Fingerprints []string // SHA-256 fingerprints of certificates to accept, in hex
+	Insecure     bool     // Accept any certificate
+}
+
+// ClientConfig returns the crypto/tls configuration for connecting to host
+func (c TLSConfig) ClientConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: host}
+
+	switch {
+	case c.Insecure:
+		config.InsecureSkipVerify = true
+	case len(c.Fingerprints) > 0:
+		// A pinned certificate is trusted on its own, which is what makes
+		// pinning useful for the self-signed certificates many MUDs use
+		pins := make(map[string]bool, len(c.Fingerprints))
+		for _, fp := range c.Fingerprints {
+			pin, err := normalizeFingerprint(fp)
+			if err != nil {
+				return nil, err
+			}
+			pins[pin] = true
+		}
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("tls: server sent no certificate")
+			}
+			fp := Fingerprint(rawCerts[0])
+			if !pins[fp] {
+				return fmt.Errorf("tls: certificate fingerprint %s is not pinned", fp)
+			}
+			return nil
+		}
+	case c.CAFile != "":
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a DER encoded certificate,
+// as lower case hex
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint accepts fingerprints with or without colons and a
+// "sha256:" prefix, in either case
+func normalizeFingerprint(fp string) (string, error) {
+	fp = strings.ToLower(strings.TrimSpace(fp))
+	fp = strings.TrimPrefix(fp, "sha256:")
+	fp = strings.ReplaceAll(fp, ":", "")
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("tls: invalid SHA-256 fingerprint %q", fp)
+	}
+	return fp, nil
+}
+
+// TLSState returns the state of the TLS session, or false if the connection
+// isn't using TLS. Transports that encrypt below the telnet stream, such as
+// secure WebSockets, report their state by implementing TLSState themselves.
+func (t *TelnetConnection) TLSState() (tls.ConnectionState, bool) {
+	switch conn := t.conn.(type) {
+	case *tls.Conn:
+		return conn.ConnectionState(), true
+	case interface {
+		TLSState() (tls.ConnectionState, bool)
+	}:
+		return conn.TLSState()
+	}
+	return tls.ConnectionState{}, false
+}
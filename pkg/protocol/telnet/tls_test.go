@@ -0,0 +1,180 @@
+package telnet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a self-signed certificate for 127.0.0.1
+func newTestCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test mud"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Failed to create certificate:", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// listenTLS starts a TLS server that sends a greeting to each client and
+// returns its port
+func listenTLS(t *testing.T, cert tls.Certificate) int {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("Welcome\r\n"))
+				buf := make([]byte, 256)
+				conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// dialTLS opens a telnet connection over TLS to the test server on port,
+// checking its certificate as config says
+func dialTLS(port int, config TLSConfig) (*TelnetConnection, error) {
+	tlsConfig, err := config.ClientConfig("127.0.0.1")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return FromConn(conn, false), nil
+}
+
+func TestTLSConnection(t *testing.T) {
+	cert := newTestCertificate(t)
+	port := listenTLS(t, cert)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(caFile, pemData, 0o600); err != nil {
+		t.Fatal("Failed to write CA file:", err)
+	}
+	fingerprint := Fingerprint(cert.Certificate[0])
+
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		wantErr string
+	}{
+		{"system roots reject self-signed", TLSConfig{}, "certificate"},
+		{"CA file", TLSConfig{CAFile: caFile}, ""},
+		{"missing CA file", TLSConfig{CAFile: filepath.Join(t.TempDir(), "none.pem")}, "reading CA file"},
+		{"pinned fingerprint", TLSConfig{Fingerprints: []string{"SHA256:" + strings.ToUpper(fingerprint)}}, ""},
+		{"wrong fingerprint", TLSConfig{Fingerprints: []string{strings.Repeat("ab", 32)}}, "not pinned"},
+		{"malformed fingerprint", TLSConfig{Fingerprints: []string{"abc"}}, "invalid SHA-256 fingerprint"},
+		{"insecure", TLSConfig{Insecure: true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := dialTLS(port, tt.config)
+			if tt.wantErr != "" {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("expected an error containing %q", tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Failed to connect:", err)
+			}
+			defer conn.Close()
+
+			state, ok := conn.TLSState()
+			if !ok || !state.HandshakeComplete {
+				t.Fatal("expected a completed TLS handshake")
+			}
+			if state.Version < tls.VersionTLS12 {
+				t.Errorf("expected TLS 1.2 or later, got %s", tls.VersionName(state.Version))
+			}
+
+			buf := make([]byte, 64)
+			n, err := conn.Read(buf)
+			if err != nil || string(buf[:n]) != "Welcome\r\n" {
+				t.Errorf("expected greeting, got %q (%v)", buf[:n], err)
+			}
+		})
+	}
+}
+
+func TestTLSAdvertisedInMTTS(t *testing.T) {
+	cert := newTestCertificate(t)
+	port := listenTLS(t, cert)
+
+	conn, err := dialTLS(port, TLSConfig{Insecure: true})
+	if err != nil {
+		t.Fatal("Failed to connect:", err)
+	}
+	defer conn.Close()
+	conn.SetTerminalType("xterm", MTTSANSI)
+
+	types := conn.terminalTypes()
+	if want := "MTTS 2561"; types[len(types)-1] != want {
+		t.Errorf("expected %q, got %q", want, types[len(types)-1])
+	}
+
+	client, _ := dialTestServer(t)
+	client.SetTerminalType("xterm", MTTSANSI)
+	types = client.terminalTypes()
+	if want := "MTTS 513"; types[len(types)-1] != want {
+		t.Errorf("expected %q for a plain connection, got %q", want, types[len(types)-1])
+	}
+	if _, ok := client.TLSState(); ok {
+		t.Error("expected no TLS state for a plain connection")
+	}
+}
@@ -0,0 +1,99 @@
+package telnet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TERMINAL-TYPE subnegotiation commands (RFC 1091)
+const (
+	ttypeIS   = 0
+	ttypeSEND = 1
+)
+
+// MTTS flags advertised in the terminal type cycle
+const (
+	MTTSANSI            = 1
+	MTTSVT100           = 2
+	MTTSUTF8            = 4
+	MTTS256Colors       = 8
+	MTTSMouseTracking   = 16
+	MTTSOSCColorPalette = 32
+	MTTSScreenReader    = 64
+	MTTSProxy           = 128
+	MTTSTrueColor       = 256
+	MTTSMNES            = 512
+	MTTSMSLP            = 1024
+	MTTSTLS             = 2048
+)
+
+// ttypeClientName is the first name sent in the terminal type cycle
+const ttypeClientName = "RUNES"
+
+// SetTerminalType sets the terminal type and MTTS flags reported to the
+// server, e.g. ("XTERM-256COLOR", MTTSANSI|MTTSUTF8|MTTS256Colors). The MNES
+// flag is always added, and the TLS flag for TLS connections.
+func (t *TelnetConnection) SetTerminalType(name string, mtts int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.termType = strings.ToUpper(name)
+	t.mtts = mtts
+	t.environChanged()
+}
+
+// terminalTypes returns the names sent in the terminal type cycle. Called
+// with t.mu held.
+func (t *TelnetConnection) terminalTypes() []string {
+	return []string{
+		ttypeClientName,
+		t.terminalType(),
+		fmt.Sprintf("MTTS %d", t.mttsFlags()),
+	}
+}
+
+// terminalType returns the terminal type reported to the server. Called
+// with t.mu held.
+func (t *TelnetConnection) terminalType() string {
+	if t.termType == "" {
+		return "ANSI"
+	}
+	return t.termType
+}
+
+// mttsFlags returns the MTTS flags reported to the server, adding those the
+// connection itself supports. Called with t.mu held.
+func (t *TelnetConnection) mttsFlags() int {
+	mtts := t.mtts | MTTSMNES
+	if _, ok := t.TLSState(); ok {
+		mtts |= MTTSTLS
+	}
+	return mtts
+}
+
+// handleTerminalType answers a TERMINAL-TYPE SEND. Each request gets the
+// next name in the cycle; the last name is repeated once to mark the end of
+// the list, after which the cycle starts over.
+func (t *TelnetConnection) handleTerminalType(data []byte) {
+	if len(data) == 0 || data[0] != ttypeSEND {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	types := t.terminalTypes()
+	var name string
+	if t.ttypeIndex < len(types) {
+		name = types[t.ttypeIndex]
+		t.ttypeIndex++
+	} else {
+		name = types[len(types)-1]
+		t.ttypeIndex = 0
+	}
+
+	msg := []byte{cmdIAC, cmdSB, optTERMINAL_TYPE, ttypeIS}
+	msg = appendEscaped(msg, []byte(name))
+	msg = append(msg, cmdIAC, cmdSE)
+	t.send(msg)
+}
@@ -0,0 +1,111 @@
+package telnet
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// ttypeIs builds the reply for one step of the terminal type cycle
+func ttypeIs(name string) []byte {
+	msg := []byte{cmdIAC, cmdSB, optTERMINAL_TYPE, ttypeIS}
+	msg = append(msg, []byte(name)...)
+	return append(msg, cmdIAC, cmdSE)
+}
+
+var ttypeSend = []byte{cmdIAC, cmdSB, optTERMINAL_TYPE, ttypeSEND, cmdIAC, cmdSE}
+
+func TestTerminalTypeCycle(t *testing.T) {
+	client, server := dialTestServer(t)
+	client.SetTerminalType("xterm-256color", MTTSANSI|MTTSUTF8|MTTS256Colors|MTTSTrueColor)
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdDO, optTERMINAL_TYPE})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdWILL, optTERMINAL_TYPE}) {
+		return
+	}
+
+	steps := []struct {
+		name string
+		want string
+	}{
+		{"client name", "RUNES"},
+		{"terminal type", "XTERM-256COLOR"},
+		{"MTTS flags", "MTTS 781"},
+		{"MTTS repeated to end the list", "MTTS 781"},
+		{"cycle restarts", "RUNES"},
+		{"second cycle terminal type", "XTERM-256COLOR"},
+	}
+	for _, step := range steps {
+		server.Write(ttypeSend)
+		if !expectBytes(t, server, ttypeIs(step.want)) {
+			t.Fatalf("step %q failed", step.name)
+		}
+	}
+}
+
+func TestTerminalTypeRenegotiationRestartsCycle(t *testing.T) {
+	client, server := dialTestServer(t)
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdDO, optTERMINAL_TYPE})
+	server.Write(ttypeSend)
+	want := append([]byte{cmdIAC, cmdWILL, optTERMINAL_TYPE}, ttypeIs("RUNES")...)
+	if !expectBytes(t, server, want) {
+		return
+	}
+
+	// Without a terminal type set, ANSI and no flags are reported
+	server.Write(ttypeSend)
+	if !expectBytes(t, server, ttypeIs("ANSI")) {
+		return
+	}
+
+	server.Write([]byte{cmdIAC, cmdDONT, optTERMINAL_TYPE})
+	server.Write([]byte{cmdIAC, cmdDO, optTERMINAL_TYPE})
+	server.Write(ttypeSend)
+	want = []byte{cmdIAC, cmdWONT, optTERMINAL_TYPE, cmdIAC, cmdWILL, optTERMINAL_TYPE}
+	want = append(want, ttypeIs("RUNES")...)
+	expectBytes(t, server, want)
+}
+
+// tlsStateConn stands in for a transport that encrypts below the telnet
+// stream, such as a secure WebSocket
+type tlsStateConn struct {
+	net.Conn
+}
+
+func (tlsStateConn) TLSState() (tls.ConnectionState, bool) {
+	return tls.ConnectionState{Version: tls.VersionTLS13}, true
+}
+
+func TestTerminalTypeTLSFlag(t *testing.T) {
+	local, server := net.Pipe()
+	client := FromConn(tlsStateConn{local}, false)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	go readAll(client)
+
+	server.Write([]byte{cmdIAC, cmdDO, optTERMINAL_TYPE})
+	if !expectBytes(t, server, []byte{cmdIAC, cmdWILL, optTERMINAL_TYPE}) {
+		return
+	}
+	for _, want := range []string{"RUNES", "ANSI", "MTTS 2560"} {
+		go server.Write(ttypeSend)
+		if !expectBytes(t, server, ttypeIs(want)) {
+			return
+		}
+	}
+}
+
+func TestTerminalTypeIgnoresIS(t *testing.T) {
+	client, server := dialTestServer(t)
+	go readAll(client)
+
+	// A stray IS from the server must not produce a reply or advance the cycle
+	server.Write([]byte{cmdIAC, cmdSB, optTERMINAL_TYPE, ttypeIS, 'X', cmdIAC, cmdSE})
+	server.Write(ttypeSend)
+	expectBytes(t, server, ttypeIs("RUNES"))
+}
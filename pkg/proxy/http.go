@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// HTTPConnect dials connections through an HTTP proxy using CONNECT
+type HTTPConnect struct {
+	Address  string // Proxy host:port
+	Username string // Empty for no authentication
+	Password string
+	Forward  Dialer // Dials the proxy
+}
+
+// DialContext connects to address through the proxy
+func (h *HTTPConnect) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("http proxy: unsupported network %q", network)
+	}
+
+	conn, err := h.Forward.DialContext(ctx, "tcp", h.Address)
+	if err != nil {
+		return nil, err
+	}
+	var proxied net.Conn
+	err = handshake(ctx, conn, func() error {
+		proxied, err = h.connect(conn, address)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy: %w", err)
+	}
+	return proxied, nil
+}
+
+// connect sends the CONNECT request and waits for the tunnel to open
+func (h *HTTPConnect) connect(conn net.Conn, address string) (net.Conn, error) {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if h.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(h.Username + ":" + h.Password))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy refused connection: %s", resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		// The server spoke first and its data arrived with the response
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads data that was buffered while reading the proxy's
+// response before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen starts a loopback listener handling each connection with serve
+func listen(t *testing.T, serve func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startMUD starts a server that greets like a MUD and then echoes
+func startMUD(t *testing.T) string {
+	return listen(t, func(conn net.Conn) {
+		conn.Write([]byte("Welcome!\r\n"))
+		io.Copy(conn, conn)
+	})
+}
+
+// pipe copies between two connections until either side closes
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(a, b); done <- struct{}{} }()
+	go func() { io.Copy(b, a); done <- struct{}{} }()
+	<-done
+}
+
+// startSOCKS5 starts a minimal SOCKS5 proxy. With a username set it
+// requires password authentication.
+func startSOCKS5(t *testing.T, username, password string) string {
+	return listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		header := make([]byte, 2)
+		io.ReadFull(r, header)
+		methods := make([]byte, header[1])
+		io.ReadFull(r, methods)
+
+		if username == "" {
+			conn.Write([]byte{socksVersion, socksAuthNone})
+		} else {
+			if !strings.ContainsRune(string(methods), socksAuthPassword) {
+				conn.Write([]byte{socksVersion, socksAuthRefused})
+				return
+			}
+			conn.Write([]byte{socksVersion, socksAuthPassword})
+			io.ReadFull(r, header)
+			user := make([]byte, header[1])
+			io.ReadFull(r, user)
+			n, _ := r.ReadByte()
+			pass := make([]byte, n)
+			io.ReadFull(r, pass)
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		req := make([]byte, 4)
+		io.ReadFull(r, req)
+		var host string
+		switch req[3] {
+		case socksIPv4:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case socksDomain:
+			n, _ := r.ReadByte()
+			name := make([]byte, n)
+			io.ReadFull(r, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(r, port)
+
+		target, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port))))
+		if err != nil {
+			conn.Write([]byte{socksVersion, 5, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close()
+		conn.Write([]byte{socksVersion, 0, 0, socksIPv4, 127, 0, 0, 1, 0, 0})
+		pipe(conn, target)
+	})
+}
+
+// startHTTPProxy starts a minimal HTTP CONNECT proxy. With a username set
+// it requires basic authentication.
+func startHTTPProxy(t *testing.T, username, password string) string {
+	return listen(t, func(conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			conn.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\n\r\n"))
+			return
+		}
+		if username != "" {
+			want := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+			if req.Header.Get("Proxy-Authorization") != want {
+				conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+				return
+			}
+		}
+
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+			return
+		}
+		defer target.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		pipe(conn, target)
+	})
+}
+
+// checkTunnel checks that a connection reaches the MUD started by startMUD
+func checkTunnel(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	if line, err := r.ReadString('\n'); err != nil || line != "Welcome!\r\n" {
+		t.Fatalf("expected greeting, got %q (%v)", line, err)
+	}
+	conn.Write([]byte("look\n"))
+	if line, err := r.ReadString('\n'); err != nil || line != "look\n" {
+		t.Errorf("expected echo, got %q (%v)", line, err)
+	}
+}
+
+func TestProxies(t *testing.T) {
+	mud := startMUD(t)
+	_, mudPort, _ := net.SplitHostPort(mud)
+
+	tests := []struct {
+		name    string
+		url     string
+		target  string
+		wantErr string
+	}{
+		{"socks5", "socks5://" + startSOCKS5(t, "", ""), mud, ""},
+		{"socks5 host name", "socks5://" + startSOCKS5(t, "", ""), "localhost:" + mudPort, ""},
+		{"socks5 auth", "socks5://bob:secret@" + startSOCKS5(t, "bob", "secret"), mud, ""},
+		{"socks5 bad password", "socks5://bob:wrong@" + startSOCKS5(t, "bob", "secret"), mud, "authentication failed"},
+		{"socks5 auth required", "socks5://" + startSOCKS5(t, "bob", "secret"), mud, "refused the authentication"},
+		{"http", "http://" + startHTTPProxy(t, "", ""), mud, ""},
+		{"http auth", "http://bob:secret@" + startHTTPProxy(t, "bob", "secret"), mud, ""},
+		{"http auth required", "http://" + startHTTPProxy(t, "bob", "secret"), mud, "407"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer, err := FromURL(tt.url, Direct)
+			if err != nil {
+				t.Fatal("FromURL failed:", err)
+			}
+			conn, err := dialer.DialContext(context.Background(), "tcp", tt.target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Dial failed:", err)
+			}
+			checkTunnel(t, conn)
+		})
+	}
+}
+
+func TestFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"", "direct"},
+		{"socks5://proxy.example.com", "socks5 proxy.example.com:1080"},
+		{"socks5h://u:p@proxy.example.com:9050", "socks5 proxy.example.com:9050 u:p"},
+		{"http://proxy.example.com:3128", "http proxy.example.com:3128"},
+		{"ftp://proxy.example.com", "error"},
+		{"socks5://", "error"},
+	}
+
+	for _, tt := range tests {
+		dialer, err := FromURL(tt.url, Direct)
+		var got string
+		switch d := dialer.(type) {
+		case *SOCKS5:
+			got = "socks5 " + d.Address
+			if d.Username != "" {
+				got += " " + d.Username + ":" + d.Password
+			}
+		case *HTTPConnect:
+			got = "http " + d.Address
+		default:
+			got = "direct"
+		}
+		if err != nil {
+			got = "error"
+		}
+		if got != tt.want {
+			t.Errorf("FromURL(%q): expected %s, got %s", tt.url, tt.want, got)
+		}
+	}
+}
+
+func TestHandshakeCancelled(t *testing.T) {
+	// A proxy that accepts connections but never answers
+	silent := listen(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	for _, scheme := range []string{"socks5", "http"} {
+		t.Run(scheme, func(t *testing.T) {
+			dialer, err := FromURL(scheme+"://"+silent, Direct)
+			if err != nil {
+				t.Fatal("FromURL failed:", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err = dialer.DialContext(ctx, "tcp", "example.com:4000")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected deadline exceeded, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("dial took %v after the deadline", elapsed)
+			}
+
+			ctx, cancel = context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			_, err = dialer.DialContext(ctx, "tcp", "example.com:4000")
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected cancellation, got %v", err)
+			}
+		})
+	}
+}
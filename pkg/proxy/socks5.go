@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 protocol values (RFC 1928, RFC 1929)
+const (
+	socksVersion = 5
+
+	socksAuthNone     = 0
+	socksAuthPassword = 2
+	socksAuthRefused  = 0xff // No acceptable methods
+
+	socksConnect = 1
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+)
+
+// socksErrors describes the reply codes a SOCKS5 proxy can fail with
+var socksErrors = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// SOCKS5 dials connections through a SOCKS5 proxy. The proxy resolves host
+// names itself.
+type SOCKS5 struct {
+	Address  string // Proxy host:port
+	Username string // Empty for no authentication
+	Password string
+	Forward  Dialer // Dials the proxy
+}
+
+// DialContext connects to address through the proxy
+func (s *SOCKS5) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("socks5: unsupported network %q", network)
+	}
+
+	conn, err := s.Forward.DialContext(ctx, "tcp", s.Address)
+	if err != nil {
+		return nil, err
+	}
+	err = handshake(ctx, conn, func() error {
+		return s.connect(conn, address)
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5: %w", err)
+	}
+	return conn, nil
+}
+
+// connect asks the proxy to open a connection to address
+func (s *SOCKS5) connect(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	// Offer password authentication only when there are credentials
+	method := byte(socksAuthNone)
+	if s.Username != "" {
+		method = socksAuthPassword
+	}
+	if _, err := conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected version %d", reply[0])
+	}
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if err := s.authenticate(conn); err != nil {
+			return err
+		}
+	case socksAuthRefused:
+		return errors.New("proxy refused the authentication methods offered")
+	default:
+		return fmt.Errorf("proxy chose unsupported authentication method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		req = append(req, socksDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socksIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socksIPv6)
+		req = append(req, ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP, then the bound address which isn't needed
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if msg, ok := socksErrors[header[1]]; ok {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("connect failed with code %d", header[1])
+	}
+	var addrLen int
+	switch header[3] {
+	case socksIPv4:
+		addrLen = net.IPv4len
+	case socksIPv6:
+		addrLen = net.IPv6len
+	case socksDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		addrLen = int(n[0])
+	default:
+		return fmt.Errorf("unknown address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// authenticate sends the username and password (RFC 1929)
+func (s *SOCKS5) authenticate(conn net.Conn) error {
+	if len(s.Username) > 255 || len(s.Password) > 255 {
+		return errors.New("username or password too long")
+	}
+	req := []byte{1, byte(len(s.Username))}
+	req = append(req, s.Username...)
+	req = append(req, byte(len(s.Password)))
+	req = append(req, s.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// deadlines implements net.Conn deadlines for connections that can't carry
+// on after an interrupted read or write, such as WebSocket and SSH
+// sessions. When a deadline passes, expire is called to drop the
+// connection and expired is set, so that the connection can report
+// os.ErrDeadlineExceeded.
+type deadlines struct {
+	expire func()
+
+	mu                    sync.Mutex
+	readTimer, writeTimer *time.Timer
+	expired               atomic.Bool
+}
+
+func (d *deadlines) SetDeadline(t time.Time) error {
+	d.SetReadDeadline(t)
+	return d.SetWriteDeadline(t)
+}
+
+func (d *deadlines) SetReadDeadline(t time.Time) error {
+	d.set(&d.readTimer, t)
+	return nil
+}
+
+func (d *deadlines) SetWriteDeadline(t time.Time) error {
+	d.set(&d.writeTimer, t)
+	return nil
+}
+
+func (d *deadlines) set(timer **time.Timer, t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if *timer != nil {
+		(*timer).Stop()
+		*timer = nil
+	}
+	if !t.IsZero() {
+		*timer = time.AfterFunc(time.Until(t), func() {
+			d.expired.Store(true)
+			d.expire()
+		})
+	}
+}
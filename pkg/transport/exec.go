@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("exec", 0, openExec)
+}
+
+// openExec runs a command with the shell and talks to it over its standard
+// input and output, as with exec:"ssh jumpbox nc mud.example.com 4000".
+// What it writes to standard error is shown like its output.
+func openExec(ctx context.Context, u *url.URL, opts Options) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stdin, toProcess, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	fromProcess, stdout, err := os.Pipe()
+	if err != nil {
+		stdin.Close()
+		toProcess.Close()
+		return nil, err
+	}
+
+	cmd := shellCommand(u.Opaque)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stdout
+	err = cmd.Start()
+
+	// The process has its own copies of its ends of the pipes
+	stdin.Close()
+	stdout.Close()
+	if err != nil {
+		toProcess.Close()
+		fromProcess.Close()
+		return nil, err
+	}
+
+	conn := &execConn{
+		cmd:     cmd,
+		command: u.Opaque,
+		w:       toProcess,
+		r:       fromProcess,
+		plain:   opts.Plain,
+		exited:  make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		close(conn.exited)
+	}()
+	return conn, nil
+}
+
+// execConn is a connection to a process run by openExec
+type execConn struct {
+	cmd     *exec.Cmd
+	command string
+	w       *os.File // Standard input of the process
+	r       *os.File // Its standard output and error
+	plain   bool
+	exited  chan struct{} // Closed once the process has exited
+
+	closeOnce sync.Once
+}
+
+func (c *execConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	return n, mapFileError(err)
+}
+
+func (c *execConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	return n, mapFileError(err)
+}
+
+// Close closes the pipes and stops the process if it is still running
+func (c *execConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.w.Close()
+		c.r.Close()
+		select {
+		case <-c.exited:
+		default:
+			stopProcess(c.cmd)
+		}
+	})
+	return nil
+}
+
+// mapFileError reports use of a closed pipe as net.ErrClosed, as a
+// net.Conn would
+func mapFileError(err error) error {
+	if errors.Is(err, os.ErrClosed) {
+		return net.ErrClosed
+	}
+	return err
+}
+
+// Telnet reports whether the output is a telnet stream
+func (c *execConn) Telnet() bool {
+	return !c.plain
+}
+
+func (c *execConn) LocalAddr() net.Addr {
+	return execAddr(c.command)
+}
+
+func (c *execConn) RemoteAddr() net.Addr {
+	return execAddr(c.command)
+}
+
+func (c *execConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *execConn) SetReadDeadline(t time.Time) error {
+	return c.r.SetReadDeadline(t)
+}
+
+func (c *execConn) SetWriteDeadline(t time.Time) error {
+	return c.w.SetWriteDeadline(t)
+}
+
+// execAddr is the address of a process: its command
+type execAddr string
+
+func (execAddr) Network() string  { return "exec" }
+func (a execAddr) String() string { return string(a) }
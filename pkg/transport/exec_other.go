@@ -0,0 +1,17 @@
+//go:build !unix
+
+package transport
+
+import (
+	"os/exec"
+)
+
+// shellCommand runs command with cmd.exe
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+// stopProcess kills the process; any it started are left running
+func stopProcess(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
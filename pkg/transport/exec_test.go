@@ -0,0 +1,112 @@
+//go:build unix
+
+package transport
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`exec:"ssh jumpbox nc mud.example 4000"`, "ssh jumpbox nc mud.example 4000"},
+		{`exec:'./mud --port 0'`, "./mud --port 0"},
+		{`EXEC:nc mud.example 4000 # comment?`, "nc mud.example 4000 # comment?"},
+	}
+	for _, tt := range tests {
+		u, err := Parse(tt.raw)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.raw, err)
+			continue
+		}
+		if host, port := HostPort(u); u.Scheme != "exec" || host != tt.want || port != 0 {
+			t.Errorf("Parse(%q) = %s %q %d, want exec %q", tt.raw, u.Scheme, host, port, tt.want)
+		}
+	}
+
+	if _, err := Parse(`exec:""`); err == nil {
+		t.Error("expected an empty command to be refused")
+	}
+}
+
+func TestExec(t *testing.T) {
+	// The process greets with IAC WILL ECHO, then echoes lines back
+	conn := open(t, `exec:printf '\377\373\001Hello\r\n'; head -n 1; echo oops >&2`, Options{})
+
+	var echo []bool
+	conn.SetEventHandler(func(e telnet.TelnetEvent) {
+		if e, ok := e.(telnet.EchoEvent); ok {
+			echo = append(echo, e.Enabled)
+		}
+	})
+	readUntil(t, conn, "Hello\r\n")
+	if len(echo) != 1 || !echo[0] {
+		t.Errorf("expected telnet negotiation to be parsed, got %v", echo)
+	}
+
+	conn.Write([]byte("look\n"))
+	rest, err := io.ReadAll(conn)
+	if err != nil || !strings.Contains(string(rest), "oops") {
+		t.Errorf("expected the output to end with standard error, got %q %v", rest, err)
+	}
+}
+
+func TestExecPlain(t *testing.T) {
+	u, err := Parse(`exec:printf '\377\373\001Hello\n'; cat`)
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+	conn, err := Open(context.Background(), u, Options{Plain: true})
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	defer conn.Close()
+	if Telnet(conn) {
+		t.Error("expected plain output not to be parsed as telnet")
+	}
+
+	r := bufio.NewReader(conn)
+	if line, _ := r.ReadString('\n'); line != "\377\373\001Hello\n" {
+		t.Errorf("expected the output as it is, got %q", line)
+	}
+	conn.Write([]byte("look\n"))
+	if line, _ := r.ReadString('\n'); line != "look\n" {
+		t.Errorf("expected the input to be echoed by cat, got %q", line)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := r.ReadByte(); !os.IsTimeout(err) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestExecClose(t *testing.T) {
+	u, err := Parse("exec:sleep 60")
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+	conn, err := Open(context.Background(), u, Options{})
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	conn.Close()
+
+	select {
+	case <-conn.(*execConn).exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected closing to stop the process")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected reads to fail once closed")
+	}
+}
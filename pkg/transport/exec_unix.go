@@ -0,0 +1,21 @@
+//go:build unix
+
+package transport
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs command with /bin/sh in a process group of its own, so
+// that stopProcess reaches any processes it starts
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// stopProcess terminates the process group of cmd
+func stopProcess(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
@@ -0,0 +1,302 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	Register("ssh", 22, openSSH)
+}
+
+// SSHConfig controls SSH logins. The zero value authenticates with the
+// keys held by the SSH agent and the default keys in ~/.ssh, and checks
+// the server against ~/.ssh/known_hosts.
+type SSHConfig struct {
+	KeyFile    string // Private key to try before the agent's, instead of the default keys
+	KnownHosts string // known_hosts file to check the server's key against
+	Insecure   bool   // Accept any host key
+}
+
+// Default private keys, tried when no key file is given
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// Terminal size requested when none is given
+const (
+	defaultCols = 80
+	defaultRows = 24
+)
+
+// openSSH logs in to an SSH server and starts a shell on a PTY. A password
+// in the URL is tried after the keys.
+func openSSH(ctx context.Context, u *url.URL, opts Options) (net.Conn, error) {
+	var config SSHConfig
+	if opts.SSH != nil {
+		config = *opts.SSH
+	}
+	hostKeyCallback, err := sshHostKeyCallback(config)
+	if err != nil {
+		return nil, err
+	}
+	signers, closeAgent, err := sshSigners(config)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	auth := []ssh.AuthMethod{ssh.PublicKeysCallback(signers)}
+	if password, ok := u.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+	name := u.User.Username()
+	if name == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("no user name in %s: %w", u.Redacted(), err)
+		}
+		name = current.Username
+	}
+
+	raw, err := opts.Dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Interrupt the handshake and session setup if ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		raw.SetDeadline(time.Unix(1, 0))
+	})
+	conn, err := startSSHSession(raw, u.Host, &ssh.ClientConfig{
+		User:            name,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}, opts)
+	if !stop() {
+		if conn != nil {
+			conn.Close()
+		}
+		raw.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// startSSHSession completes the SSH handshake on raw and starts a shell
+func startSSHSession(raw net.Conn, addr string, config *ssh.ClientConfig, opts Options) (*sshConn, error) {
+	clientConn, chans, reqs, err := ssh.NewClientConn(raw, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	client := ssh.NewClient(clientConn, chans, reqs)
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	term := opts.Term
+	if term == "" {
+		term = "xterm"
+	}
+	cols, rows := opts.Cols, opts.Rows
+	if cols <= 0 || rows <= 0 {
+		cols, rows = defaultCols, defaultRows
+	}
+	// Input is echoed locally, so the PTY mustn't echo it again
+	modes := ssh.TerminalModes{ssh.ECHO: 0, ssh.TTY_OP_ISPEED: 38400, ssh.TTY_OP_OSPEED: 38400}
+	if err := session.RequestPty(term, rows, cols, modes); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	stdout, w := io.Pipe()
+	session.Stdout = w
+	session.Stderr = w
+	if err := session.Shell(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	conn := &sshConn{client: client, session: session, stdin: stdin, stdout: stdout}
+	conn.expire = func() { client.Close() }
+	go func() {
+		// Output ends when the shell exits or the connection drops
+		session.Wait()
+		w.Close()
+	}()
+	return conn, nil
+}
+
+// sshSigners returns a function listing the keys to authenticate with: the
+// key file, or the default keys if none is given, followed by the agent's.
+// The returned function closes the connection to the agent.
+func sshSigners(config SSHConfig) (func() ([]ssh.Signer, error), func(), error) {
+	var signers []ssh.Signer
+	if config.KeyFile != "" {
+		signer, err := loadSSHKey(config.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range defaultSSHKeys {
+			// Missing keys and ones protected by a passphrase are left to
+			// the agent
+			if signer, err := loadSSHKey(filepath.Join(home, ".ssh", name)); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+
+	var agentClient agent.ExtendedAgent
+	closeAgent := func() {}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			agentClient = agent.NewClient(conn)
+			closeAgent = func() { conn.Close() }
+		}
+	}
+
+	list := func() ([]ssh.Signer, error) {
+		if agentClient == nil {
+			return signers, nil
+		}
+		agentSigners, err := agentClient.Signers()
+		if err != nil && len(signers) == 0 {
+			return nil, fmt.Errorf("ssh agent: %w", err)
+		}
+		return append(signers[:len(signers):len(signers)], agentSigners...), nil
+	}
+	return list, closeAgent, nil
+}
+
+// loadSSHKey reads an unencrypted private key
+func loadSSHKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// sshHostKeyCallback returns the check for the server's host key
+func sshHostKeyCallback(config SSHConfig) (ssh.HostKeyCallback, error) {
+	if config.Insecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	path := config.KnownHosts
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %w", err)
+	}
+
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(host, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("unknown host key for %s: %s %s is not in %s", host, key.Type(), ssh.FingerprintSHA256(key), path)
+		}
+		return err
+	}, nil
+}
+
+// sshConn is a shell session on an SSH server. It carries plain text
+// rather than telnet.
+type sshConn struct {
+	deadlines
+
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  *io.PipeReader
+	closed  atomic.Bool
+}
+
+func (c *sshConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err != nil {
+		err = c.mapError(err)
+	}
+	return n, err
+}
+
+func (c *sshConn) Write(p []byte) (int, error) {
+	n, err := c.stdin.Write(p)
+	if err != nil {
+		err = c.mapError(err)
+	}
+	return n, err
+}
+
+func (c *sshConn) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	c.session.Close()
+	return c.client.Close()
+}
+
+// mapError turns errors after the connection was closed or dropped into the
+// ones expected from a net.Conn
+func (c *sshConn) mapError(err error) error {
+	switch {
+	case c.closed.Load():
+		return net.ErrClosed
+	case c.expired.Load():
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
+
+// SetWindowSize tells the server the terminal has been resized
+func (c *sshConn) SetWindowSize(cols, rows int) {
+	c.session.WindowChange(rows, cols)
+}
+
+// Telnet returns false: SSH sessions carry plain text
+func (c *sshConn) Telnet() bool {
+	return false
+}
+
+func (c *sshConn) LocalAddr() net.Addr {
+	return c.client.LocalAddr()
+}
+
+func (c *sshConn) RemoteAddr() net.Addr {
+	return c.client.RemoteAddr()
+}
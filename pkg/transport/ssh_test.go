@@ -0,0 +1,329 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshServer is an in-process SSH server running a line-based shell that
+// greets the client and says goodbye on "quit"
+type sshServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+	ptys    chan string // "term cols rows" for each PTY requested
+	resizes chan string // "cols rows" for each window change
+	lines   chan string // Lines typed into the shell
+}
+
+// newSSHKey generates an ed25519 key
+func newSSHKey(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal("Failed to create signer:", err)
+	}
+	return signer, key
+}
+
+// startSSHServer starts a server accepting the given key, or the password
+// "secret"
+func startSSHServer(t *testing.T, authorized ssh.PublicKey) *sshServer {
+	t.Helper()
+	hostKey, _ := newSSHKey(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorized != nil && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong password")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &sshServer{
+		addr:    ln.Addr().String(),
+		hostKey: hostKey.PublicKey(),
+		ptys:    make(chan string, 1),
+		resizes: make(chan string, 8),
+		lines:   make(chan string, 8),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "sessions only")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(channel, requests)
+	}
+}
+
+func (s *sshServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			var pty struct {
+				Term                      string
+				Cols, Rows, Width, Height uint32
+				Modes                     string
+			}
+			ssh.Unmarshal(req.Payload, &pty)
+			s.ptys <- fmt.Sprintf("%s %d %d", pty.Term, pty.Cols, pty.Rows)
+			req.Reply(true, nil)
+		case "window-change":
+			var size struct{ Cols, Rows, Width, Height uint32 }
+			ssh.Unmarshal(req.Payload, &size)
+			s.resizes <- fmt.Sprintf("%d %d", size.Cols, size.Rows)
+		case "shell":
+			req.Reply(true, nil)
+			go s.shell(channel)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *sshServer) shell(channel ssh.Channel) {
+	fmt.Fprint(channel, "Welcome to the SSH MUD\r\n")
+	var line []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := channel.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			if b != '\n' {
+				line = append(line, b)
+				continue
+			}
+			s.lines <- string(line)
+			if string(line) == "quit" {
+				fmt.Fprint(channel, "Bye\r\n")
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				channel.Close()
+				return
+			}
+			line = line[:0]
+		}
+	}
+}
+
+// knownHostsFile writes a known_hosts file trusting the server
+func (s *sshServer) knownHostsFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal("Failed to write known_hosts:", err)
+	}
+	return path
+}
+
+// openSSHTest connects to the server with no default keys or agent
+// besides the ones the test sets up
+func openSSHTest(t *testing.T, rawURL string, opts Options) (net.Conn, error) {
+	t.Helper()
+	u, err := Parse(rawURL)
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return Open(ctx, u, opts)
+}
+
+// expectValue waits for a value from the server
+func expectValue(t *testing.T, values chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-values:
+		if got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func TestSSH(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	signer, key := newSSHKey(t)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal("Failed to marshal key:", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_test")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal("Failed to write key:", err)
+	}
+	server := startSSHServer(t, signer.PublicKey())
+
+	conn, err := openSSHTest(t, "ssh://player@"+server.addr, Options{
+		SSH:  &SSHConfig{KeyFile: keyFile, KnownHosts: server.knownHostsFile(t)},
+		Term: "xterm-256color",
+		Cols: 100,
+		Rows: 40,
+	})
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	defer conn.Close()
+
+	if Telnet(conn) {
+		t.Error("expected SSH sessions to carry plain text")
+	}
+	expectValue(t, server.ptys, "xterm-256color 100 40")
+	readUntil(t, conn, "Welcome to the SSH MUD\r\n")
+
+	conn.Write([]byte("look\n"))
+	expectValue(t, server.lines, "look")
+
+	conn.(interface{ SetWindowSize(cols, rows int) }).SetWindowSize(120, 50)
+	expectValue(t, server.resizes, "120 50")
+
+	conn.Write([]byte("quit\n"))
+	rest, err := io.ReadAll(conn)
+	if err != nil || string(rest) != "Bye\r\n" {
+		t.Errorf("expected the session to end with Bye, got %q %v", rest, err)
+	}
+}
+
+func TestSSHAgent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	signer, key := newSSHKey(t)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal("Failed to add key to agent:", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("unix sockets unavailable:", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	server := startSSHServer(t, signer.PublicKey())
+	conn, err := openSSHTest(t, "ssh://player@"+server.addr, Options{SSH: &SSHConfig{Insecure: true}})
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	defer conn.Close()
+	readUntil(t, conn, "Welcome")
+}
+
+func TestSSHPassword(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := startSSHServer(t, nil)
+
+	conn, err := openSSHTest(t, "ssh://player:secret@"+server.addr, Options{SSH: &SSHConfig{Insecure: true}})
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	defer conn.Close()
+	expectValue(t, server.ptys, "xterm 80 24")
+	readUntil(t, conn, "Welcome")
+
+	if _, err := openSSHTest(t, "ssh://player:wrong@"+server.addr, Options{SSH: &SSHConfig{Insecure: true}}); err == nil {
+		t.Error("expected a wrong password to be refused")
+	}
+}
+
+func TestSSHUnknownHost(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := startSSHServer(t, nil)
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(knownHosts, nil, 0600)
+	_, err := openSSHTest(t, "ssh://player:secret@"+server.addr, Options{SSH: &SSHConfig{KnownHosts: knownHosts}})
+	if err == nil || !strings.Contains(err.Error(), "unknown host key") {
+		t.Errorf("expected the unknown host key to be refused, got %v", err)
+	}
+}
+
+func TestSSHDeadline(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := startSSHServer(t, nil)
+
+	conn, err := openSSHTest(t, "ssh://player:secret@"+server.addr, Options{SSH: &SSHConfig{Insecure: true}})
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	defer conn.Close()
+	readUntil(t, conn, "Welcome to the SSH MUD\r\n")
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 64)); !os.IsTimeout(err) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+)
+
+func init() {
+	Register("telnet", 23, openTCP)
+	Register("tls", 992, openTLS)
+}
+
+// openTCP opens a plain TCP connection
+func openTCP(ctx context.Context, u *url.URL, opts Options) (net.Conn, error) {
+	return opts.Dialer.DialContext(ctx, "tcp", u.Host)
+}
+
+// openTLS opens a TCP connection and completes a TLS handshake on it
+func openTLS(ctx context.Context, u *url.URL, opts Options) (net.Conn, error) {
+	config, err := tlsConfig(u, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := opts.Dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// tlsConfig returns the crypto/tls configuration for connecting to u
+func tlsConfig(u *url.URL, opts Options) (*tls.Config, error) {
+	var config telnet.TLSConfig
+	if opts.TLS != nil {
+		config = *opts.TLS
+	}
+	return config.ClientConfig(u.Hostname())
+}
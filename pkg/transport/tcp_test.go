@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+)
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	// A server that accepts the connection but never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	u := &url.URL{Scheme: "tls", Host: ln.Addr().String()}
+	_, err = Open(ctx, u, Options{TLS: &telnet.TLSConfig{Insecure: true}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("handshake took %v after the deadline", elapsed)
+	}
+}
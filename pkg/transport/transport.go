@@ -0,0 +1,150 @@
+// Package transport opens the connections MUD sessions run over. Each kind
+// of connection is chosen by URL scheme, such as telnet://host:port or
+// wss://host/path, and gives a byte stream that telnet parsing runs on top
+// of.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+	"github.com/mmcdole/runes/pkg/proxy"
+)
+
+// Options control how a transport connects
+type Options struct {
+	Dialer proxy.Dialer      // Opens network connections, directly or through a proxy
+	TLS    *telnet.TLSConfig // Certificate checks for encrypted transports, nil for the defaults
+	SSH    *SSHConfig        // Authentication and host key checks for ssh://, nil for the defaults
+	Plain  bool              // Treat the output of exec: commands as plain text rather than telnet
+
+	// Terminal requested by transports that open one, such as SSH
+	Term       string
+	Cols, Rows int
+}
+
+// Factory opens a connection to the server at u, which has a port
+type Factory func(ctx context.Context, u *url.URL, opts Options) (net.Conn, error)
+
+type scheme struct {
+	open        Factory
+	defaultPort int
+}
+
+var (
+	mu      sync.RWMutex
+	schemes = make(map[string]scheme)
+)
+
+// Register makes a transport available for URLs with the given scheme.
+// URLs without a port get defaultPort. A defaultPort of 0 registers a
+// scheme that takes a command rather than a host, as in exec:command.
+func Register(name string, defaultPort int, open Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	schemes[strings.ToLower(name)] = scheme{open, defaultPort}
+}
+
+// Schemes returns the registered schemes in order
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(schemes))
+	for name := range schemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse parses a connection URL, checking that its scheme is registered
+// and adding the scheme's default port if it has none
+func Parse(raw string) (*url.URL, error) {
+	if name, command, ok := strings.Cut(raw, ":"); ok {
+		if s, ok := lookup(name); ok && s.defaultPort == 0 {
+			return parseCommand(name, command)
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", raw, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	s, ok := lookup(u.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("unsupported scheme %q, expected one of %s", u.Scheme, strings.Join(Schemes(), ", "))
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in %q", raw)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(s.defaultPort))
+	} else if port, err := strconv.Atoi(u.Port()); err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %q", u.Port())
+	}
+	return u, nil
+}
+
+// parseCommand parses the command of a scheme such as exec:, which is
+// taken as it is apart from any quotes around the whole of it
+func parseCommand(name, command string) (*url.URL, error) {
+	command = strings.TrimSpace(command)
+	if len(command) >= 2 && (command[0] == '"' || command[0] == '\'') && command[len(command)-1] == command[0] {
+		command = command[1 : len(command)-1]
+	}
+	if command == "" {
+		return nil, fmt.Errorf("no command in %s: URL", name)
+	}
+	return &url.URL{Scheme: strings.ToLower(name), Opaque: command}, nil
+}
+
+// lookup returns the registered scheme with the given name
+func lookup(name string) (scheme, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	s, ok := schemes[strings.ToLower(name)]
+	return s, ok
+}
+
+// Open connects to the server at a URL such as telnet://example.com:4000,
+// giving up if ctx is done first
+func Open(ctx context.Context, u *url.URL, opts Options) (net.Conn, error) {
+	s, ok := lookup(u.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if opts.Dialer == nil {
+		opts.Dialer = proxy.Direct
+	}
+	return s.open(ctx, u, opts)
+}
+
+// Telnet reports whether a connection opened by Open carries a telnet
+// stream. Connections that carry plain text, such as SSH sessions, have a
+// Telnet method returning false.
+func Telnet(conn net.Conn) bool {
+	if conn, ok := conn.(interface{ Telnet() bool }); ok {
+		return conn.Telnet()
+	}
+	return true
+}
+
+// HostPort returns the host and port of a URL returned by Parse. For
+// schemes that take a command it returns the command and port 0.
+func HostPort(u *url.URL) (string, int) {
+	if u.Opaque != "" {
+		return u.Opaque, 0
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return u.Hostname(), port
+}
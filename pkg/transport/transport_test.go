@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"telnet://example.com", "telnet://example.com:23"},
+		{"TELNET://example.com:4000", "telnet://example.com:4000"},
+		{"tls://example.com", "tls://example.com:992"},
+		{"ws://example.com/mud", "ws://example.com:80/mud"},
+		{"wss://example.com:8443/ws", "wss://example.com:8443/ws"},
+		{"telnet://[::1]", "telnet://[::1]:23"},
+	}
+	for _, tt := range tests {
+		u, err := Parse(tt.raw)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.raw, err)
+			continue
+		}
+		if got := u.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"gopher://example.com", "unsupported scheme"},
+		{"example.com:4000", "unsupported scheme"},
+		{"telnet://", "no host"},
+		{"telnet://example.com:0", "invalid port"},
+		{"telnet://example.com:70000", "invalid port"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.raw)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q): expected an error containing %q, got %v", tt.raw, tt.want, err)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	u, err := Parse("ws://example.com/mud")
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+	if host, port := HostPort(u); host != "example.com" || port != 80 {
+		t.Errorf("expected example.com 80, got %s %d", host, port)
+	}
+}
@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coder/websocket"
+)
+
+func init() {
+	Register("ws", 80, openWebSocket)
+	Register("wss", 443, openWebSocket)
+}
+
+// webSocketProtocols are the subprotocols offered to the server. Gateways
+// such as websockify carry the telnet stream in binary messages.
+var webSocketProtocols = []string{"binary"}
+
+// maxWebSocketMessage is the largest message accepted from the server
+const maxWebSocketMessage = 1 << 22
+
+// openWebSocket connects to a ws:// or wss:// endpoint
+func openWebSocket(ctx context.Context, u *url.URL, opts Options) (net.Conn, error) {
+	// Remember the addresses of the underlying connection, which the
+	// WebSocket library doesn't expose
+	var local, remote net.Addr
+	httpTransport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := opts.Dialer.DialContext(ctx, network, address)
+			if err == nil {
+				local, remote = conn.LocalAddr(), conn.RemoteAddr()
+			}
+			return conn, err
+		},
+	}
+	if u.Scheme == "wss" {
+		config, err := tlsConfig(u, opts)
+		if err != nil {
+			return nil, err
+		}
+		httpTransport.TLSClientConfig = config
+	}
+
+	ws, resp, err := websocket.Dial(ctx, u.String(), &websocket.DialOptions{
+		HTTPClient:   &http.Client{Transport: httpTransport},
+		Subprotocols: webSocketProtocols,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	ws.SetReadLimit(maxWebSocketMessage)
+
+	conn := &wsConn{ws: ws, local: local, remote: remote}
+	conn.ctx, conn.cancel = context.WithCancel(context.Background())
+	conn.expire = conn.cancel
+	conn.msgType.Store(int32(websocket.MessageBinary))
+	if resp != nil && resp.TLS != nil {
+		conn.tls = resp.TLS
+	}
+	return conn, nil
+}
+
+// wsConn carries a byte stream in WebSocket messages. It reads text and
+// binary messages alike, and writes messages of the type the server last
+// sent, binary until it has sent any.
+type wsConn struct {
+	deadlines
+
+	ws            *websocket.Conn
+	local, remote net.Addr
+	tls           *tls.ConnectionState
+
+	// Cancelled by Close or a deadline passing
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	readMu  sync.Mutex
+	reader  io.Reader // Message being read, nil between messages
+	msgType atomic.Int32
+
+	closed atomic.Bool
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		if c.reader == nil {
+			typ, r, err := c.ws.Reader(c.ctx)
+			if err != nil {
+				return 0, c.mapError(err)
+			}
+			c.msgType.Store(int32(typ))
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			err = nil
+		}
+		if err != nil {
+			return n, c.mapError(err)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	typ := websocket.MessageType(c.msgType.Load())
+	if err := c.ws.Write(c.ctx, typ, p); err != nil {
+		return 0, c.mapError(err)
+	}
+	return len(p), nil
+}
+
+// Close ends the session with a normal closure, or drops the connection if
+// a deadline has already interrupted it
+func (c *wsConn) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	defer c.cancel()
+	if c.expired.Load() {
+		return c.ws.CloseNow()
+	}
+	return c.ws.Close(websocket.StatusNormalClosure, "")
+}
+
+// mapError turns WebSocket errors into the ones expected from a net.Conn
+func (c *wsConn) mapError(err error) error {
+	switch {
+	case c.closed.Load():
+		return net.ErrClosed
+	case c.expired.Load():
+		return os.ErrDeadlineExceeded
+	}
+	switch websocket.CloseStatus(err) {
+	case websocket.StatusNormalClosure, websocket.StatusGoingAway:
+		return io.EOF
+	}
+	if errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+	return err
+}
+
+// TLSState returns the state of the TLS session of a wss:// connection
+func (c *wsConn) TLSState() (tls.ConnectionState, bool) {
+	if c.tls == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *c.tls, true
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	if c.local == nil {
+		return wsAddr{}
+	}
+	return c.local
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return wsAddr{}
+	}
+	return c.remote
+}
+
+// wsAddr stands in for an address the WebSocket library didn't report
+type wsAddr struct{}
+
+func (wsAddr) Network() string { return "websocket" }
+func (wsAddr) String() string  { return "websocket" }
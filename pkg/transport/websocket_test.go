@@ -0,0 +1,188 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/mmcdole/runes/pkg/protocol/telnet"
+)
+
+// serveWebSocket starts an in-process WebSocket server running session for
+// each client and returns its URL, wss:// if secure
+func serveWebSocket(t *testing.T, secure bool, session func(ctx context.Context, ws *websocket.Conn) error) string {
+	t.Helper()
+	errs := make(chan error, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: webSocketProtocols})
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer ws.CloseNow()
+		errs <- session(r.Context(), ws)
+	})
+
+	var server *httptest.Server
+	if secure {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Error("Server failed:", err)
+			}
+		default:
+		}
+	})
+	// http:// becomes ws:// and https:// wss://
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/mud"
+}
+
+// expectMessage reads a message and checks its type and contents
+func expectMessage(ctx context.Context, ws *websocket.Conn, typ websocket.MessageType, want string) error {
+	gotType, got, err := ws.Read(ctx)
+	if err != nil {
+		return err
+	}
+	if gotType != typ || string(got) != want {
+		return fmt.Errorf("expected %s message %q, got %s %q", typ, want, gotType, got)
+	}
+	return nil
+}
+
+// open connects to rawURL and wraps the connection for telnet parsing
+func open(t *testing.T, rawURL string, opts Options) *telnet.TelnetConnection {
+	t.Helper()
+	u, err := Parse(rawURL)
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Open(ctx, u, opts)
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	tc := telnet.FromConn(conn, false)
+	t.Cleanup(func() { tc.Close() })
+	return tc
+}
+
+// readUntil reads parsed text from conn until it contains want
+func readUntil(t *testing.T, conn io.Reader, want string) string {
+	t.Helper()
+	var out bytes.Buffer
+	buf := make([]byte, 64)
+	for !strings.Contains(out.String(), want) {
+		n, err := conn.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			t.Fatalf("Read failed waiting for %q, got %q: %v", want, out.String(), err)
+		}
+	}
+	return out.String()
+}
+
+func TestWebSocket(t *testing.T) {
+	url := serveWebSocket(t, false, func(ctx context.Context, ws *websocket.Conn) error {
+		// Negotiation is answered in binary until the server sends text
+		err := ws.Write(ctx, websocket.MessageBinary, []byte("\xff\xfb\x01Password: "))
+		if err == nil {
+			err = expectMessage(ctx, ws, websocket.MessageBinary, "\xff\xfd\x01")
+		}
+		if err == nil {
+			err = ws.Write(ctx, websocket.MessageText, []byte("Welcome!\r\n"))
+		}
+		if err == nil {
+			err = expectMessage(ctx, ws, websocket.MessageText, "look\r\n")
+		}
+		if err == nil {
+			err = ws.Close(websocket.StatusNormalClosure, "")
+		}
+		return err
+	})
+
+	conn := open(t, url, Options{})
+	var echo []bool
+	conn.SetEventHandler(func(e telnet.TelnetEvent) {
+		if e, ok := e.(telnet.EchoEvent); ok {
+			echo = append(echo, e.Enabled)
+		}
+	})
+
+	if got := readUntil(t, conn, "Welcome!\r\n"); got != "Password: Welcome!\r\n" {
+		t.Errorf("expected the text of both messages, got %q", got)
+	}
+	if len(echo) != 1 || !echo[0] {
+		t.Errorf("expected the server to take over echoing, got %v", echo)
+	}
+	if _, err := conn.Write([]byte("look\r\n")); err != nil {
+		t.Fatal("Write failed:", err)
+	}
+	if rest, err := io.ReadAll(conn); err != nil || len(bytes.TrimSpace(rest)) > 0 {
+		t.Errorf("expected a clean close, got %q %v", rest, err)
+	}
+}
+
+func TestWebSocketTLS(t *testing.T) {
+	url := serveWebSocket(t, true, func(ctx context.Context, ws *websocket.Conn) error {
+		err := ws.Write(ctx, websocket.MessageBinary, []byte("Secure\r\n"))
+		if err == nil {
+			_, _, err = ws.Read(ctx)
+		}
+		if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+			err = nil
+		}
+		return err
+	})
+
+	conn := open(t, url, Options{TLS: &telnet.TLSConfig{Insecure: true}})
+	readUntil(t, conn, "Secure")
+	if _, ok := conn.TLSState(); !ok {
+		t.Error("expected the connection to report its TLS state")
+	}
+}
+
+func TestWebSocketCertificate(t *testing.T) {
+	url := serveWebSocket(t, true, func(ctx context.Context, ws *websocket.Conn) error {
+		return nil
+	})
+	u, err := Parse(url)
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+	if _, err := Open(context.Background(), u, Options{}); err == nil {
+		t.Error("expected an untrusted certificate to be rejected")
+	}
+}
+
+func TestWebSocketDeadline(t *testing.T) {
+	url := serveWebSocket(t, false, func(ctx context.Context, ws *websocket.Conn) error {
+		ws.Read(ctx)
+		return nil
+	})
+
+	conn := open(t, url, Options{})
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 64))
+	if !os.IsTimeout(err) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("expected a net.Error timeout, got %T", err)
+	}
+}
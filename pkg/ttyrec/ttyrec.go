@@ -0,0 +1,134 @@
+// Package ttyrec reads and writes recordings in the ttyrec format: a series
+// of frames, each a 12 byte header (seconds, microseconds and data length as
+// little-endian uint32s) followed by the data.
+package ttyrec
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const headerSize = 12
+
+// MaxFrameSize is the largest frame Next accepts, anything bigger is taken
+// to be a corrupt file
+const MaxFrameSize = 1 << 24
+
+// Frame is a chunk of data and the time it was received
+type Frame struct {
+	Time time.Time
+	Data []byte
+}
+
+// Writer writes frames to a recording
+type Writer struct {
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+// NewWriter returns a writer recording to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write records p as a frame received now, so a Writer can be handed to
+// anything that copies data as it arrives
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.WriteFrame(Frame{Time: time.Now(), Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteFrame records a frame. Once a write fails every later one returns
+// the same error.
+func (w *Writer) WriteFrame(f Frame) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(f.Data) > MaxFrameSize {
+		return fmt.Errorf("ttyrec: frame of %d bytes is too large", len(f.Data))
+	}
+
+	usec := f.Time.UnixMicro()
+	w.buf = binary.LittleEndian.AppendUint32(w.buf[:0], uint32(usec/1e6))
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(usec%1e6))
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(len(f.Data)))
+	w.buf = append(w.buf, f.Data...)
+	_, w.err = w.w.Write(w.buf)
+	return w.err
+}
+
+// Err returns the error that stopped the writer, if any
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Reader reads frames from a recording
+type Reader struct {
+	r      io.Reader
+	header [headerSize]byte
+}
+
+// NewReader returns a reader for the recording in r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next returns the next frame, or io.EOF at the end of the recording. A
+// frame cut short returns io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Frame, error) {
+	if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+		return Frame{}, err
+	}
+	sec := binary.LittleEndian.Uint32(r.header[0:])
+	usec := binary.LittleEndian.Uint32(r.header[4:])
+	size := binary.LittleEndian.Uint32(r.header[8:])
+	if size > MaxFrameSize {
+		return Frame{}, fmt.Errorf("ttyrec: frame of %d bytes is too large", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	return Frame{Time: time.UnixMicro(int64(sec)*1e6 + int64(usec)), Data: data}, nil
+}
+
+// Play writes each frame's data to w, waiting between frames for the time
+// that passed between them when they were recorded divided by speed. A
+// speed of zero or less plays the frames without waiting.
+func Play(ctx context.Context, w io.Writer, r *Reader, speed float64) error {
+	var last time.Time
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if speed > 0 && !last.IsZero() {
+			if gap := f.Time.Sub(last); gap > 0 {
+				select {
+				case <-time.After(time.Duration(float64(gap) / speed)):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		last = f.Time
+
+		if _, err := w.Write(f.Data); err != nil {
+			return err
+		}
+	}
+}
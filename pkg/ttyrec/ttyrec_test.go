@@ -0,0 +1,88 @@
+package ttyrec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 250000000)
+	frames := []Frame{
+		{start, []byte("Welcome!\r\n")},
+		{start.Add(1500 * time.Millisecond), []byte{0xff, 0xfb, 0x01}},
+		{start.Add(2 * time.Second), nil},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatal("WriteFrame failed:", err)
+		}
+	}
+	if want := 3*headerSize + 13; buf.Len() != want {
+		t.Errorf("expected %d bytes, got %d", want, buf.Len())
+	}
+
+	r := NewReader(&buf)
+	for i, want := range frames {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !got.Time.Equal(want.Time) || !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("frame %d: expected %v %q, got %v %q", i, want.Time, want.Data, got.Time, got.Data)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	NewWriter(&buf).WriteFrame(Frame{time.Now(), []byte("cut short")})
+
+	r := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+
+	huge := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}
+	if _, err := NewReader(bytes.NewReader(huge)).Next(); err == nil {
+		t.Error("expected an error for an oversized frame")
+	}
+}
+
+func TestPlay(t *testing.T) {
+	start := time.Now()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteFrame(Frame{start, []byte("one ")})
+	w.WriteFrame(Frame{start.Add(200 * time.Millisecond), []byte("two ")})
+	w.WriteFrame(Frame{start.Add(400 * time.Millisecond), []byte("three")})
+	recording := buf.Bytes()
+
+	var out bytes.Buffer
+	began := time.Now()
+	if err := Play(context.Background(), &out, NewReader(bytes.NewReader(recording)), 4); err != nil {
+		t.Fatal("Play failed:", err)
+	}
+	if elapsed := time.Since(began); elapsed < 100*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("expected about 100ms at 4x speed, took %v", elapsed)
+	}
+	if out.String() != "one two three" {
+		t.Errorf("expected all frames, got %q", out.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out.Reset()
+	err := Play(ctx, &out, NewReader(bytes.NewReader(recording)), 1)
+	if err != context.Canceled || out.String() != "one " {
+		t.Errorf("expected to stop after the first frame, got %q (%v)", out.String(), err)
+	}
+}